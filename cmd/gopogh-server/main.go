@@ -1,48 +1,190 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/medyagh/gopogh/pkg/alert"
 	"github.com/medyagh/gopogh/pkg/db"
 	"github.com/medyagh/gopogh/pkg/handler"
+	"github.com/medyagh/gopogh/pkg/importer"
+	"github.com/medyagh/gopogh/pkg/owners"
+	"github.com/medyagh/gopogh/pkg/store"
+	"github.com/medyagh/gopogh/pkg/tracker"
 )
 
+var dbBackend = flag.String("db_backend", "postgres", "sql database driver")
 var dbPath = flag.String("db_path", "", "path to postgres db in the form of 'user=DB_USER dbname=DB_NAME password=DB_PASS'")
 var dbHost = flag.String("db_host", "", "host of the db")
 var useCloudSQL = flag.Bool("use_cloudsql", false, "whether the database is a cloudsql db")
 var useIAMAuth = flag.Bool("use_iam_auth", false, "whether to use IAM to authenticate with the cloudsql db")
+var dbTimeout = flag.Duration("db_timeout", 30*time.Second, "how long a database call of a request can take, 0 for no limit. the calls also stop when the client goes away")
+var dbMaxOpenConns = flag.Int("db_max_open_conns", 0, "maximum number of open connections to the db, unlimited if 0")
+var dbMaxIdleConns = flag.Int("db_max_idle_conns", 0, "maximum number of idle connections to the db, 2 if 0")
+var dbConnMaxLifetime = flag.Duration("db_conn_max_lifetime", 0, "how long a connection to the db can be reused, forever if 0")
+var ingestTokens = flag.String("ingest_tokens", "", "comma separated project=token pairs allowed to upload to /ingest, defaults to the INGEST_TOKENS environment variable. a project of '*' allows uploading to every project")
+var defaultProject = flag.String("default_project", "", "project shown when a request has no project query parameter, defaults to the DEFAULT_PROJECT environment variable")
+var reportDir = flag.String("report_dir", "", "directory to store uploaded reports in, defaults to the REPORT_DIR environment variable. report storage is disabled if empty")
+var alertConfig = flag.String("alert_config", "", "path to the json alert rules and notifiers evaluated after each ingestion, defaults to the ALERT_CONFIG environment variable. alerting is disabled if empty")
+var issueConfig = flag.String("issue_config", "", "path to the json issue tracking configuration, for filing issues for the tests that stay flaky. defaults to the ISSUE_CONFIG environment variable, disabled if empty")
+var ownersFile = flag.String("owners", "", "path to a CODEOWNERS style file mapping test name patterns to their owners, applied to the ingested reports. defaults to the OWNERS_FILE environment variable")
+var retention = flag.String("retention", "", "age of the runs to prune every day, for example 180d. the pruned runs are rolled up into daily tables. defaults to the RETENTION environment variable, runs are kept forever if empty")
+var cacheTTL = flag.Duration("cache_ttl", 5*time.Minute, "how long the dashboard responses are cached, they are also dropped when new data is ingested. 0 disables caching")
+var dataDir = flag.String("data_dir", "", "directory of summaries written by gopogh -out_summary to serve the charts from, in memory instead of from a database. defaults to the DATA_DIR environment variable")
+var dataRefresh = flag.Duration("data_refresh", time.Minute, "how often the new and changed summaries of -data_dir are loaded, 0 to only load them at startup")
+var reportFallbackURL = flag.String("report_fallback_url", "", "url of reports not stored by the server with {env} and {commit} placeholders, defaults to the REPORT_FALLBACK_URL environment variable")
 
 func main() {
 	flag.Parse()
 	flagValues := db.FlagValues{
-		Backend:     "postgres",
+		Backend:     *dbBackend,
 		Host:        *dbHost,
 		Path:        *dbPath,
 		UseCloudSQL: *useCloudSQL,
 		UseIAMAuth:  *useIAMAuth,
+
+		QueryTimeout:    *dbTimeout,
+		MaxOpenConns:    *dbMaxOpenConns,
+		MaxIdleConns:    *dbMaxIdleConns,
+		ConnMaxLifetime: *dbConnMaxLifetime,
+	}
+	var testOwners *owners.Owners
+	if path := flagOrEnv(*ownersFile, "OWNERS_FILE"); path != "" {
+		o, err := owners.Load(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		testOwners = o
+	}
+	var datab db.Datab
+	var summaries *importer.Importer
+	// the summaries loaded so far and their modification time
+	seen := map[string]time.Time{}
+	summaryDir := flagOrEnv(*dataDir, "DATA_DIR")
+	if summaryDir != "" {
+		datab = db.NewMemory()
+		summaries = &importer.Importer{Database: datab, Owners: testOwners}
+		res, err := summaries.ImportSummaries(context.Background(), summaryDir, seen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logFailed(res)
+		log.Printf("loaded %d summaries from %s", res.Imported, summaryDir)
+	} else {
+		var err error
+		datab, err = db.FromEnv(flagValues)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	datab, err := db.FromEnv(flagValues)
+	tokens, err := handler.ParseTokens(flagOrEnv(*ingestTokens, "INGEST_TOKENS"))
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(tokens) > 0 {
+		if err := datab.Initialize(context.Background()); err != nil {
+			log.Fatalf("failed to initialize the database for ingestion: %v", err)
+		}
+	}
+	if s := flagOrEnv(*retention, "RETENTION"); s != "" {
+		age, err := db.ParseAge(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := datab.Initialize(context.Background()); err != nil {
+			log.Fatalf("failed to initialize the database for pruning: %v", err)
+		}
+		db.StartRetention(datab, age)
+	}
 	db := handler.DB{
-		Database: datab,
+		Database:       datab,
+		DefaultProject: flagOrEnv(*defaultProject, "DEFAULT_PROJECT"),
+		Tokens:         tokens,
+		ReportFallback: flagOrEnv(*reportFallbackURL, "REPORT_FALLBACK_URL"),
+		Owners:         testOwners,
+	}
+	if *cacheTTL > 0 {
+		db.Cache = handler.NewCache(*cacheTTL)
+	}
+	if path := flagOrEnv(*alertConfig, "ALERT_CONFIG"); path != "" {
+		cfg, err := alert.LoadConfig(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		db.Alerts = alert.NewEvaluator(cfg, datab)
+	}
+	if summaries != nil && *dataRefresh > 0 {
+		go refreshSummaries(summaries, summaryDir, seen, *dataRefresh, db.Cache)
+	}
+	if path := flagOrEnv(*issueConfig, "ISSUE_CONFIG"); path != "" {
+		cfg, t, err := tracker.LoadConfig(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cfg.Project == "" {
+			cfg.Project = db.DefaultProject
+		}
+		job := &tracker.Job{Config: cfg, Tracker: t, Source: datab}
+		job.Start()
+	}
+	if dir := flagOrEnv(*reportDir, "REPORT_DIR"); dir != "" {
+		db.Reports, err = store.New(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 	// Create an HTTP server and register the handlers
 
-	http.HandleFunc("/db", db.ServeEnvironmentTestsAndTestCases)
+	http.HandleFunc("/db", db.Cached(db.ServeEnvironmentTestsAndTestCases))
+
+	http.HandleFunc("/db/runs", db.Cached(db.ServeRuns))
+
+	http.HandleFunc("/db/tests", db.Cached(db.ServeTestCases))
+
+	http.HandleFunc("/export/", db.ServeExport)
+
+	http.HandleFunc("/env", db.Cached(db.ServeEnvCharts))
+
+	http.HandleFunc("/test", db.Cached(db.ServeTestCharts))
+
+	http.HandleFunc("/history", db.Cached(db.ServeTestHistory))
+
+	http.HandleFunc("/summary", db.Cached(db.ServeOverview))
+
+	http.HandleFunc("/regressions", db.Cached(db.ServeDurationRegressions))
 
-	http.HandleFunc("/env", db.ServeEnvCharts)
+	http.HandleFunc("/owners", db.Cached(db.ServeOwners))
 
-	http.HandleFunc("/test", db.ServeTestCharts)
+	http.HandleFunc("/trend", db.Cached(db.ServeDailyTrend))
 
-	http.HandleFunc("/summary", db.ServeOverview)
+	// the v2 endpoints return the commits of the chart points as json arrays instead of strings
+	http.HandleFunc("/v2/db", db.Cached(db.ServeEnvironmentTestsAndTestCases))
+
+	http.HandleFunc("/v2/env", db.Cached(db.ServeEnvCharts))
+
+	http.HandleFunc("/v2/test", db.Cached(db.ServeTestCharts))
+
+	http.HandleFunc("/v2/history", db.Cached(db.ServeTestHistory))
+
+	http.HandleFunc("/v2/summary", db.Cached(db.ServeOverview))
+
+	http.HandleFunc("/v2/regressions", db.Cached(db.ServeDurationRegressions))
+
+	http.HandleFunc("/v2/owners", db.Cached(db.ServeOwners))
+
+	http.HandleFunc("/v2/trend", db.Cached(db.ServeDailyTrend))
 
 	http.HandleFunc("/version", handler.ServeGopoghVersion)
 
+	http.HandleFunc("/ingest", db.ServeIngest)
+
+	http.HandleFunc("/report/", db.ServeReport)
+
 	http.HandleFunc("/", handler.ServeHTML)
 
 	// Start the HTTP server
@@ -51,3 +193,34 @@ func main() {
 		log.Fatalf("failed to start HTTP server: %v", err)
 	}
 }
+
+// refreshSummaries loads the new and changed summaries of dir every interval, dropping the cached responses when there are some
+func refreshSummaries(i *importer.Importer, dir string, seen map[string]time.Time, interval time.Duration, cache *handler.Cache) {
+	for {
+		time.Sleep(interval)
+		res, err := i.ImportSummaries(context.Background(), dir, seen)
+		if err != nil {
+			log.Printf("failed to refresh the summaries: %v", err)
+			continue
+		}
+		logFailed(res)
+		if res.Imported > 0 && cache != nil {
+			cache.Invalidate()
+		}
+	}
+}
+
+// logFailed logs the summaries that could not be loaded
+func logFailed(res *importer.Result) {
+	for f, err := range res.Failed {
+		log.Printf("failed to load %s: %v", f, err)
+	}
+}
+
+// flagOrEnv returns the flag value if set, otherwise the value of the environment variable
+func flagOrEnv(flagValue, envName string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(envName)
+}
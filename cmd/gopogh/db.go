@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/report"
+)
+
+// defaultDBTimeout bounds the calls to the database, like the uploads to a gopogh-server
+const defaultDBTimeout = 5 * time.Minute
+
+// dbCommand runs the gopogh db subcommands
+func dbCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gopogh db prune|bench|flush [flags]")
+	}
+	switch args[0] {
+	case "prune":
+		return prune(args[1:])
+	case "bench":
+		return bench(args[1:])
+	case "flush":
+		return flush(args[1:])
+	default:
+		return fmt.Errorf("unknown db command %q, expected prune, bench or flush", args[0])
+	}
+}
+
+// dbFlagSet returns the flag set of a subcommand with the database flags registered
+func dbFlagSet(name string) (*flag.FlagSet, *db.FlagValues) {
+	fs := flag.NewFlagSet("gopogh "+name, flag.ExitOnError)
+	fv := &db.FlagValues{}
+	fs.StringVar(&fv.Backend, "db_backend", "", "sql database driver, defaults to the DB_BACKEND environment variable")
+	fs.StringVar(&fv.Host, "db_host", "", "host of the db, defaults to the DB_HOST environment variable")
+	fs.StringVar(&fv.Path, "db_path", "", "path to sql database/database file, defaults to the DB_PATH environment variable")
+	fs.BoolVar(&fv.UseCloudSQL, "use_cloudsql", false, "whether the database is a cloudsql db")
+	fs.BoolVar(&fv.UseIAMAuth, "use_iam_auth", false, "whether to use IAM to authenticate with the cloudsql db")
+	fs.DurationVar(&fv.QueryTimeout, "db_timeout", defaultDBTimeout, "how long a call to the database can take, 0 for no limit")
+	return fs, fv
+}
+
+// commandContext returns the context of a subcommand, canceled on interrupt
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// prune rolls the runs older than --older-than up into the daily tables and deletes them
+func prune(args []string) error {
+	fs, fv := dbFlagSet("db prune")
+	olderThan := fs.String("older-than", "", "age of the runs to prune, for example 180d or 36h")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	if *olderThan == "" {
+		return fmt.Errorf("please provide the age of the runs to prune using --older-than")
+	}
+	age, err := db.ParseAge(*olderThan)
+	if err != nil {
+		return err
+	}
+	database, err := db.FromEnv(*fv)
+	if err != nil {
+		return err
+	}
+	if err := database.Initialize(ctx); err != nil {
+		return err
+	}
+	before := time.Now().Add(-age)
+	pruned, err := database.Prune(ctx, before)
+	if err != nil {
+		return err
+	}
+	if pruned == nil {
+		return fmt.Errorf("pruning is not supported by the database backend")
+	}
+	fmt.Printf("pruned %d runs and %d test cases before %s\n", pruned.EnvironmentTests, pruned.TestCases, before.Format(time.RFC3339))
+	return nil
+}
+
+// flush stores the results spooled by the runs that could not store them
+func flush(args []string) error {
+	fs, fv := dbFlagSet("db flush")
+	spoolDir := fs.String("db_spool", "", "directory of the spooled results, the results spooled for the db of the db flags are stored")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *spoolDir == "" {
+		return fmt.Errorf("please provide the spool directory using -db_spool")
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	spool := db.NewSpool(*spoolDir, *fv)
+	pending, err := spool.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Printf("no spooled results in %s\n", *spoolDir)
+		return nil
+	}
+	database, err := db.FromEnv(*fv)
+	if err != nil {
+		return err
+	}
+	if err := database.Initialize(ctx); err != nil {
+		return err
+	}
+	flushed, err := spool.Flush(ctx, database)
+	fmt.Printf("stored %d of %d spooled runs\n", flushed, len(pending))
+	return err
+}
+
+// bench measures how fast runs of synthetic test cases are stored, first inserted and then replaced
+func bench(args []string) error {
+	fs, fv := dbFlagSet("db bench")
+	runs := fs.Int("runs", 10, "number of runs to store")
+	tests := fs.Int("tests", 5000, "number of test cases of each run")
+	project := fs.String("project", "gopogh-bench", "project the runs are stored in, better kept out of the projects of a production database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *runs <= 0 || *tests <= 0 {
+		return fmt.Errorf("-runs and -tests need to be positive")
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	database, err := db.FromEnv(*fv)
+	if err != nil {
+		return err
+	}
+	if err := database.Initialize(ctx); err != nil {
+		return err
+	}
+	start := time.Now().Add(-time.Duration(*runs) * time.Hour)
+	for _, pass := range []string{"insert", "replace"} {
+		began := time.Now()
+		for i := 0; i < *runs; i++ {
+			envRow, testRows := benchRun(*project, i, *tests, start.Add(time.Duration(i)*time.Hour))
+			if err := database.Set(ctx, envRow, testRows); err != nil {
+				return fmt.Errorf("failed to store run %d: %v", i, err)
+			}
+		}
+		took := time.Since(began)
+		rows := *runs * *tests
+		fmt.Printf("%s: %d runs of %d test cases in %s, %.0f test cases/s, %s per run\n",
+			pass, *runs, *tests, took.Round(time.Millisecond), float64(rows)/took.Seconds(), (took / time.Duration(*runs)).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// benchRun returns the rows of the i-th synthetic run of bench, every 10th test case failing
+func benchRun(project string, i, tests int, testTime time.Time) (models.DBEnvironmentTest, []models.DBTestCase) {
+	commit := fmt.Sprintf("bench%035d", i)
+	envRow := models.DBEnvironmentTest{
+		Project:       project,
+		Branch:        "master",
+		CommitID:      commit,
+		EnvName:       "Bench_Linux",
+		GopoghTime:    time.Now(),
+		TestTime:      testTime,
+		GopoghVersion: report.Version,
+		Trigger:       "bench",
+	}
+	testRows := make([]models.DBTestCase, 0, tests)
+	for t := 0; t < tests; t++ {
+		result := "pass"
+		if t%10 == 0 {
+			result = "fail"
+			envRow.NumberOfFail++
+		} else {
+			envRow.NumberOfPass++
+		}
+		testRows = append(testRows, models.DBTestCase{
+			Project:   project,
+			Branch:    "master",
+			CommitID:  commit,
+			EnvName:   envRow.EnvName,
+			TestName:  fmt.Sprintf("TestBench/%d", t),
+			Result:    result,
+			Duration:  float64(t%60) + 0.5,
+			TestOrder: t,
+			TestTime:  testTime,
+		})
+		envRow.TotalDuration += float64(t%60) + 0.5
+	}
+	return envRow, testRows
+}
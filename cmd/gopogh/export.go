@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/export"
+)
+
+// exportCommand writes the runs or test cases of the database to a CSV or Parquet file
+func exportCommand(args []string) error {
+	fs, fv := dbFlagSet("export")
+	table := fs.String("table", "runs", "table to export, runs or tests")
+	format := fs.String("format", export.CSV, "format of the export, csv or parquet")
+	out := fs.String("out", "", "path to the output file, defaults to stdout")
+	f := db.Filter{Labels: labelsFlag{}}
+	q := db.RowQuery{}
+	var from, to string
+	fs.StringVar(&f.Project, "project", "", "project of the runs")
+	fs.StringVar(&f.Branch, "branch", "", "branch of the runs, all branches if empty")
+	fs.StringVar(&f.PR, "pr", "", "pull request of the runs, only post-merge runs if empty")
+	fs.BoolVar(&f.AllRuns, "all_runs", false, "include the runs of pull requests")
+	fs.Var(labelsFlag(f.Labels), "label", "key=value label the runs need to have, can be repeated")
+	fs.StringVar(&from, "from", "", "export the runs since this date (2006-01-02 or RFC3339), 90 days before -to by default")
+	fs.StringVar(&to, "to", "", "export the runs before this date (2006-01-02 or RFC3339), now by default")
+	fs.StringVar(&q.Env, "env", "", "environment of the runs")
+	fs.StringVar(&q.Commit, "commit", "", "commit of the runs")
+	fs.StringVar(&q.Test, "test", "", "test name of the test cases")
+	fs.StringVar(&q.Result, "result", "", "result of the test cases")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	if f.From, err = parseDate(from); err != nil {
+		return fmt.Errorf("invalid -from: %v", err)
+	}
+	if f.To, err = parseDate(to); err != nil {
+		return fmt.Errorf("invalid -to: %v", err)
+	}
+	if len(f.Labels) == 0 {
+		f.Labels = nil
+	}
+	var write func(context.Context, io.Writer, db.Datab, db.Filter, db.RowQuery, string) (int, error)
+	switch *table {
+	case "runs":
+		write = export.Runs
+	case "tests":
+		write = export.TestCases
+	default:
+		return fmt.Errorf("unknown table %q, expected runs or tests", *table)
+	}
+	if *format != export.CSV && *format != export.Parquet {
+		return fmt.Errorf("unknown export format %q, expected csv or parquet", *format)
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	database, err := db.FromEnv(*fv)
+	if err != nil {
+		return err
+	}
+	if err := database.Initialize(ctx); err != nil {
+		return err
+	}
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %v", *out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	n, err := write(ctx, w, database, f, q, *format)
+	if err != nil {
+		return fmt.Errorf("failed to export %s: %v", *table, err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d %s\n", n, *table)
+	return nil
+}
+
+// parseDate parses a 2006-01-02 date or a RFC3339 time, the zero time if s is empty
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
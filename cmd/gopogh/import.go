@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/importer"
+	"github.com/medyagh/gopogh/pkg/owners"
+)
+
+// importCommand stores the archived test2json logs of a directory listed in a manifest
+func importCommand(args []string) error {
+	fs, fv := dbFlagSet("import")
+	dir := fs.String("dir", "", "directory of the test2json logs to import")
+	manifestPath := fs.String("manifest", "", "path to the json manifest of the logs, defaults to manifest.json in -dir")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of logs parsed at the same time")
+	ownersFile := fs.String("owners", "", "path to a CODEOWNERS style file mapping test name patterns to their owners")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("please provide the directory of the logs using -dir")
+	}
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(*dir, "manifest.json")
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	m, err := importer.LoadManifest(*manifestPath)
+	if err != nil {
+		return err
+	}
+	im := &importer.Importer{Workers: *workers}
+	if *ownersFile != "" {
+		if im.Owners, err = owners.Load(*ownersFile); err != nil {
+			return err
+		}
+	}
+	database, err := db.FromEnv(*fv)
+	if err != nil {
+		return err
+	}
+	if err := database.Initialize(ctx); err != nil {
+		return err
+	}
+	im.Database = database
+	res, importErr := im.Import(ctx, *dir, m)
+	if res == nil {
+		return importErr
+	}
+	manifestRel, _ := filepath.Rel(*dir, *manifestPath)
+	for _, f := range res.Unlisted {
+		if f != manifestRel {
+			fmt.Printf("skipped %s: not listed in the manifest\n", f)
+		}
+	}
+	for f, err := range res.Failed {
+		fmt.Printf("failed to import %s: %v\n", f, err)
+	}
+	fmt.Printf("imported %d runs\n", res.Imported)
+	if importErr != nil {
+		return fmt.Errorf("import stopped: %v", importErr)
+	}
+	if len(res.Failed) > 0 {
+		return fmt.Errorf("failed to import %d logs, run the import again once fixed", len(res.Failed))
+	}
+	return nil
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 
+	"github.com/medyagh/gopogh/pkg/client"
 	"github.com/medyagh/gopogh/pkg/db"
 	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/owners"
 	"github.com/medyagh/gopogh/pkg/parser"
 	"github.com/medyagh/gopogh/pkg/report"
 )
@@ -21,18 +29,59 @@ var (
 	dbPath         = flag.String("db_path", "", "path to sql database/database file. if using postgres in the form of 'user=DB_USER dbname=DB_NAME password=DB_PASS'")
 	useCloudSQL    = flag.Bool("use_cloudsql", false, "whether the database is a cloudsql db")
 	useIAMAuth     = flag.Bool("use_iam_auth", false, "whether to use IAM to authenticate with the cloudsql db")
+	dbTimeout      = flag.Duration("db_timeout", defaultDBTimeout, "how long storing the results in the db can take, 0 for no limit")
+	dbRetries      = flag.Int("db_retries", db.DefaultRetries, "number of times a failed connection or write to the db is retried")
+	dbSpool        = flag.String("db_spool", "", "directory the results are spooled to when they cannot be stored in the db, stored by the next run or 'gopogh db flush' with the same db flags. spooling is disabled if empty")
+	serverURL      = flag.String("server_url", "", "url of a gopogh-server to upload the results to instead of connecting to the db")
+	serverToken    = flag.String("server_token", "", "project token for uploading to the gopogh-server, defaults to the GOPOGH_SERVER_TOKEN environment variable")
 	reportName     = flag.String("name", "", "report name")
 	reportPR       = flag.String("pr", "", "Pull request number")
 	reportDetails  = flag.String("details", "", "report details (for example test args...)")
+	reportCommit   = flag.String("commit", "", "commit sha the tests ran on")
+	parentCommit   = flag.String("parent_commit", "", "sha of the parent of the commit, for example the output of 'git rev-parse HEAD^'")
+	commitOrder    = flag.Int64("commit_order", 0, "position of the commit in the branch history, for example the output of 'git rev-list --count HEAD'. used to order the runs by commit")
 	reportRepo     = flag.String("repo", "", "source repo")
+	reportBranch   = flag.String("branch", "", "branch the tests ran on, for example master")
+	goVersion      = flag.String("go_version", "", "go version the tests ran with, defaults to the output of 'go env GOVERSION'")
+	goOS           = flag.String("goos", runtime.GOOS, "GOOS the tests ran on")
+	goArch         = flag.String("goarch", runtime.GOARCH, "GOARCH the tests ran on")
+	hostname       = flag.String("hostname", "", "hostname of the runner, defaults to the hostname of this machine")
+	trigger        = flag.String("trigger", os.Getenv("GITHUB_EVENT_NAME"), "what started the run, for example push, schedule or pull_request")
+	labels         = labelsFlag{}
 	inPath         = flag.String("in", "", "path to JSON file produced by go tool test2json")
 	outPath        = flag.String("out", "", "(deprecated use  -out_html instead) path to HTML output file")
 	outHTMLPath    = flag.String("out_html", "", "path to HTML output file")
 	outSummaryPath = flag.String("out_summary", "", "path to json summary output file")
+	ownersPath     = flag.String("owners", "", "path to a CODEOWNERS style file mapping test name patterns to their owners")
 	version        = flag.Bool("version", false, "shows version")
 )
 
+func init() {
+	flag.Var(labels, "label", "key=value label of the run, can be repeated")
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		if err := dbCommand(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := exportCommand(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := importCommand(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 	flag.Parse()
 	if *version {
 		fmt.Printf("Version %s Build %s", report.Version, report.Build)
@@ -58,27 +107,25 @@ func main() {
 		os.Exit(1)
 	}
 	groups := parser.ProcessEvents(events)
-	r := models.ReportDetail{Name: *reportName, Details: *reportDetails, PR: *reportPR, RepoName: *reportRepo}
+	r := models.ReportDetail{Name: *reportName, Details: *reportDetails, Commit: *reportCommit, ParentCommit: *parentCommit, CommitOrder: *commitOrder, PR: *reportPR, RepoName: *reportRepo, Branch: *reportBranch, Run: runMetadata()}
+	if *reportCommit == "" && r.CommitID() != "" {
+		fmt.Println("passing the commit sha with -details is deprecated, use -commit instead")
+	}
 	c, err := report.Generate(r, groups)
 	if err != nil {
 		fmt.Printf("failed to generate report: %v", err)
 		os.Exit(1)
 	}
-
-	if dbVarProvided(*dbPath, *dbBackend, *dbHost) {
-		flagValues := db.FlagValues{
-			Backend:     *dbBackend,
-			Host:        *dbHost,
-			Path:        *dbPath,
-			UseCloudSQL: *useCloudSQL,
-			UseIAMAuth:  *useIAMAuth,
-		}
-		if err := c.SQL(flagValues); err != nil {
+	if *ownersPath != "" {
+		o, err := owners.Load(*ownersPath)
+		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		c.SetOwners(o)
 	}
 
+	// the outputs are written before storing the results, so a failing db or server does not lose them
 	html, err := c.HTML()
 	if err != nil {
 		fmt.Printf("failed to convert report to html: %v", err)
@@ -108,6 +155,122 @@ func main() {
 		}
 		fmt.Println(string(j))
 	}
+
+	if *serverURL == "" && dbVarProvided(*dbPath, *dbBackend, *dbHost) {
+		flagValues := db.FlagValues{
+			Backend:     *dbBackend,
+			Host:        *dbHost,
+			Path:        *dbPath,
+			UseCloudSQL: *useCloudSQL,
+			UseIAMAuth:  *useIAMAuth,
+			Retries:     *dbRetries,
+		}
+		// bounds the migrations and storing the results, so a CI job does not hang on a stuck db
+		ctx := context.Background()
+		if *dbTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *dbTimeout)
+			defer cancel()
+		}
+		if err := storeResults(ctx, c, flagValues, *dbSpool); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if *serverURL != "" {
+		if err := upload(c, html, *serverURL, *serverToken); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+// storeResults stores the report in the db, spooling it to spoolDir if it cannot be stored
+func storeResults(ctx context.Context, c report.DisplayContent, fv db.FlagValues, spoolDir string) error {
+	if c.Detail.CommitID() == "" {
+		return fmt.Errorf("please provide the commit of the run using -commit, it is needed to store the results")
+	}
+	var spool *db.Spool
+	if spoolDir != "" {
+		spool = db.NewSpool(spoolDir, fv)
+	}
+	envRow, testRows := c.DBRows()
+	spooled, err := db.Write(ctx, fv, spool, envRow, testRows)
+	if err != nil && spooled != "" {
+		fmt.Printf("failed to store the results in the db, they are spooled to %s and stored by the next run or 'gopogh db flush': %v\n", spooled, err)
+		return nil
+	}
+	return err
+}
+
+// upload sends the report summary and the HTML report to a gopogh-server
+func upload(c report.DisplayContent, html []byte, serverURL, token string) error {
+	if token == "" {
+		token = os.Getenv("GOPOGH_SERVER_TOKEN")
+	}
+	summary, err := c.ShortSummary()
+	if err != nil {
+		return fmt.Errorf("failed to convert report to json: %v", err)
+	}
+	cl := client.New(serverURL, token)
+	project, err := cl.UploadSummary(summary)
+	if err != nil {
+		return err
+	}
+	if html == nil {
+		return nil
+	}
+	// without -repo the server stores the run in the project of the token
+	err = cl.UploadReport(project, c.Detail.Name, c.Detail.CommitID(), html)
+	if errors.Is(err, client.ErrNotSupported) {
+		fmt.Println("the server does not store reports, only the results were uploaded")
+		return nil
+	}
+	return err
+}
+
+// runMetadata returns the metadata of the run from the flags, detecting the unset ones
+func runMetadata() models.RunMetadata {
+	run := models.RunMetadata{
+		GoVersion: *goVersion,
+		GOOS:      *goOS,
+		GOARCH:    *goArch,
+		Hostname:  *hostname,
+		Trigger:   *trigger,
+	}
+	if len(labels) > 0 {
+		run.Labels = models.Labels(labels)
+	}
+	if run.GoVersion == "" {
+		if out, err := exec.Command("go", "env", "GOVERSION").Output(); err == nil {
+			run.GoVersion = strings.TrimSpace(string(out))
+		}
+	}
+	if run.Hostname == "" {
+		run.Hostname, _ = os.Hostname()
+	}
+	return run
+}
+
+// labelsFlag is a repeatable key=value flag
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labelsFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	l[key] = value
+	return nil
 }
 
 // dbVarProvided checks whether any of the database flags/environment variables are set
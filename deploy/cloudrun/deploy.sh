@@ -11,7 +11,7 @@ docker push "${IMAGE}" || exit 2
 gcloud run deploy gopogh-server \
     --project k8s-minikube \
     --image "${IMAGE}" \
-    --set-env-vars="DB_HOST=${DB_HOST},DB_PATH=${DB_PATH}" \
+    --set-env-vars="DB_HOST=${DB_HOST},DB_PATH=${DB_PATH},DEFAULT_PROJECT=github.com/kubernetes/minikube/,REPORT_FALLBACK_URL=https://storage.googleapis.com/minikube-builds/logs/master/{commit}/{env}.html" \
     --allow-unauthenticated \
     --region us-central1 \
     --memory 4Gi \
@@ -0,0 +1,217 @@
+// Package alert evaluates rules against the runs ingested by gopogh-server and notifies about the matches
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// rule types
+const (
+	// FlakeRate matches the failed tests whose failure percentage in the window crosses Threshold with the run
+	FlakeRate = "flake_rate"
+	// FailJump matches the runs with at least Threshold more failures than the average of the window
+	FailJump = "fail_jump"
+	// NewFailure matches the tests that fail in the run and passed in the previous run
+	NewFailure = "new_failure"
+)
+
+const (
+	defaultWindowDays = 15
+	defaultMinRuns    = 3
+)
+
+// Rule is a condition on an ingested run, evaluated against the post-merge runs of the same environment and branch
+type Rule struct {
+	Name string `json:"name"`
+	// Type is flake_rate, fail_jump or new_failure
+	Type      string  `json:"type"`
+	Threshold float64 `json:"threshold"`
+	// Project, Env and Branch limit the rule to the runs of a project, environment or branch, any if empty
+	Project string `json:"project"`
+	Env     string `json:"env"`
+	Branch  string `json:"branch"`
+	// MinRuns is the number of runs in the window needed for flake_rate and fail_jump to match, 3 if not set
+	MinRuns int `json:"minRuns"`
+}
+
+// Alert is a match of a rule
+type Alert struct {
+	Rule    string `json:"rule"`
+	Type    string `json:"type"`
+	Project string `json:"project"`
+	Env     string `json:"env"`
+	Branch  string `json:"branch"`
+	Commit  string `json:"commit"`
+	// Test is the test the alert is about, empty for fail_jump
+	Test    string `json:"test,omitempty"`
+	Message string `json:"message"`
+}
+
+// Config is the alerting configuration file of gopogh-server
+type Config struct {
+	Rules []Rule `json:"rules"`
+	// WindowDays is the number of days of runs flake_rate and fail_jump look at, 15 if not set
+	WindowDays int       `json:"windowDays"`
+	Webhooks   []Webhook `json:"webhooks"`
+	SMTP       []SMTP    `json:"smtp"`
+}
+
+// StatsSource returns the stats of the runs before a run, implemented by db.Datab
+type StatsSource interface {
+	GetRunStats(ctx context.Context, run models.DBEnvironmentTest, since time.Time) (*models.DBRunStats, error)
+}
+
+// Evaluator evaluates the rules after each ingestion and sends the alerts to the notifiers
+type Evaluator struct {
+	Rules     []Rule
+	Window    time.Duration
+	Notifiers []Notifier
+	Stats     StatsSource
+}
+
+// LoadConfig reads a json alerting configuration file
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c, fmt.Errorf("failed to read alert config: %v", err)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("failed to parse alert config: %v", err)
+	}
+	for _, r := range c.Rules {
+		switch r.Type {
+		case FlakeRate, FailJump, NewFailure:
+		default:
+			return c, fmt.Errorf("unknown type %q of alert rule %q", r.Type, r.Name)
+		}
+	}
+	return c, nil
+}
+
+// NewEvaluator returns an evaluator of the rules of the config reading the stats from s
+func NewEvaluator(c Config, s StatsSource) *Evaluator {
+	days := c.WindowDays
+	if days <= 0 {
+		days = defaultWindowDays
+	}
+	e := &Evaluator{
+		Rules:  c.Rules,
+		Window: time.Duration(days) * 24 * time.Hour,
+		Stats:  s,
+	}
+	for _, w := range c.Webhooks {
+		e.Notifiers = append(e.Notifiers, w)
+	}
+	for _, s := range c.SMTP {
+		e.Notifiers = append(e.Notifiers, s)
+	}
+	return e
+}
+
+// Run evaluates the rules against a stored run and notifies about the alerts, errors are logged.
+// Runs of pull requests are not evaluated.
+func (e *Evaluator) Run(ctx context.Context, run models.DBEnvironmentTest, tests []models.DBTestCase) {
+	if run.PR != "" || len(e.Rules) == 0 {
+		return
+	}
+	stats, err := e.Stats.GetRunStats(ctx, run, run.TestTime.Add(-e.Window))
+	if err != nil {
+		log.Printf("failed to get the stats for alerting on %s %s: %v", run.EnvName, run.CommitID, err)
+		return
+	}
+	if stats == nil {
+		log.Printf("alerting is not supported by the database backend")
+		return
+	}
+	alerts := Evaluate(e.Rules, run, tests, *stats)
+	if len(alerts) == 0 {
+		return
+	}
+	for _, n := range e.Notifiers {
+		if err := n.Notify(alerts); err != nil {
+			log.Printf("failed to send %d alerts: %v", len(alerts), err)
+		}
+	}
+}
+
+// Evaluate returns the alerts of the rules matching the run, given the stats of the runs before it
+func Evaluate(rules []Rule, run models.DBEnvironmentTest, tests []models.DBTestCase, stats models.DBRunStats) []Alert {
+	var failed []string
+	for _, t := range tests {
+		if t.Result == "fail" {
+			failed = append(failed, t.TestName)
+		}
+	}
+	sort.Strings(failed)
+
+	var alerts []Alert
+	for _, r := range rules {
+		if !r.applies(run) {
+			continue
+		}
+		alert := func(test, format string, a ...interface{}) {
+			alerts = append(alerts, Alert{
+				Rule:    r.Name,
+				Type:    r.Type,
+				Project: run.Project,
+				Env:     run.EnvName,
+				Branch:  run.Branch,
+				Commit:  run.CommitID,
+				Test:    test,
+				Message: fmt.Sprintf(format, a...),
+			})
+		}
+		minRuns := r.MinRuns
+		if minRuns <= 0 {
+			minRuns = defaultMinRuns
+		}
+
+		switch r.Type {
+		case NewFailure:
+			for _, t := range failed {
+				if stats.PrevResults[t] == "pass" {
+					alert(t, "%s started failing on %s at %s, it passed at %s", t, run.EnvName, run.CommitID, stats.PrevCommit)
+				}
+			}
+		case FlakeRate:
+			for _, t := range failed {
+				runs, fails := stats.TestRuns[t], stats.TestFails[t]
+				if runs+1 < minRuns {
+					continue
+				}
+				before := 0.0
+				if runs > 0 {
+					before = float64(fails) * 100 / float64(runs)
+				}
+				after := float64(fails+1) * 100 / float64(runs+1)
+				if before < r.Threshold && after >= r.Threshold {
+					alert(t, "flake rate of %s on %s went from %.2f%% to %.2f%% (%d failures in %d runs) at %s", t, run.EnvName, before, after, fails+1, runs+1, run.CommitID)
+				}
+			}
+		case FailJump:
+			if stats.EnvRuns < minRuns {
+				continue
+			}
+			if float64(run.NumberOfFail)-stats.AvgFails >= r.Threshold {
+				alert("", "%d tests failed on %s at %s, the average of the last %d runs is %.2f", run.NumberOfFail, run.EnvName, run.CommitID, stats.EnvRuns, stats.AvgFails)
+			}
+		}
+	}
+	return alerts
+}
+
+// applies returns whether the rule applies to the run
+func (r Rule) applies(run models.DBEnvironmentTest) bool {
+	return (r.Project == "" || r.Project == run.Project) &&
+		(r.Env == "" || r.Env == run.EnvName) &&
+		(r.Branch == "" || r.Branch == run.Branch)
+}
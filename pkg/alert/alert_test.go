@@ -0,0 +1,75 @@
+package alert
+
+import (
+	"testing"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+func TestEvaluate(t *testing.T) {
+	run := models.DBEnvironmentTest{Project: "p", EnvName: "Docker_Linux", Branch: "master", CommitID: "c2", NumberOfFail: 6}
+	tests := []models.DBTestCase{
+		{TestName: "TestA", Result: "fail"},
+		{TestName: "TestB", Result: "fail"},
+		{TestName: "TestC", Result: "pass"},
+	}
+	stats := models.DBRunStats{
+		EnvRuns:     4,
+		AvgFails:    1,
+		PrevCommit:  "c1",
+		PrevResults: map[string]string{"TestA": "pass", "TestB": "fail", "TestC": "pass"},
+		// TestA goes from 0% to 20% with the run, TestB was at 50% already
+		TestRuns:  map[string]int{"TestA": 4, "TestB": 4, "TestC": 4},
+		TestFails: map[string]int{"TestA": 0, "TestB": 2, "TestC": 0},
+	}
+
+	tcs := []struct {
+		desc  string
+		rule  Rule
+		tests []string
+	}{
+		{desc: "new failure", rule: Rule{Name: "r", Type: NewFailure}, tests: []string{"TestA"}},
+		{desc: "flake rate crossing the threshold", rule: Rule{Name: "r", Type: FlakeRate, Threshold: 20}, tests: []string{"TestA"}},
+		{desc: "flake rate not crossing the threshold", rule: Rule{Name: "r", Type: FlakeRate, Threshold: 30}, tests: nil},
+		{desc: "flake rate with too few runs", rule: Rule{Name: "r", Type: FlakeRate, Threshold: 20, MinRuns: 10}, tests: nil},
+		{desc: "fail jump", rule: Rule{Name: "r", Type: FailJump, Threshold: 5}, tests: []string{""}},
+		{desc: "fail jump below the threshold", rule: Rule{Name: "r", Type: FailJump, Threshold: 6}, tests: nil},
+		{desc: "other branch", rule: Rule{Name: "r", Type: NewFailure, Branch: "release"}, tests: nil},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.desc, func(t *testing.T) {
+			alerts := Evaluate([]Rule{tc.rule}, run, tests, stats)
+			if len(alerts) != len(tc.tests) {
+				t.Fatalf("got %d alerts, want %d: %+v", len(alerts), len(tc.tests), alerts)
+			}
+			for i, a := range alerts {
+				if a.Test != tc.tests[i] || a.Rule != "r" || a.Type != tc.rule.Type || a.Env != run.EnvName || a.Commit != run.CommitID {
+					t.Errorf("got alert %+v, want one about %q", a, tc.tests[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateNoDuplicate(t *testing.T) {
+	rules := []Rule{{Name: "flaky", Type: FlakeRate, Threshold: 20}}
+	stats := models.DBRunStats{TestRuns: map[string]int{}, TestFails: map[string]int{}, PrevResults: map[string]string{}}
+	// the runs before the first failure of TestA
+	stats.TestRuns["TestA"] = 4
+	failing := []models.DBTestCase{{TestName: "TestA", Result: "fail"}}
+
+	var got int
+	for i, commit := range []string{"c1", "c2", "c3"} {
+		run := models.DBEnvironmentTest{EnvName: "Docker_Linux", CommitID: commit}
+		got += len(Evaluate(rules, run, failing, stats))
+		// the next run sees this one in its stats
+		stats.TestRuns["TestA"]++
+		stats.TestFails["TestA"]++
+		if i == 0 && got != 1 {
+			t.Fatalf("got %d alerts when crossing the threshold, want 1", got)
+		}
+	}
+	if got != 1 {
+		t.Errorf("got %d alerts for a test staying above the threshold, want 1", got)
+	}
+}
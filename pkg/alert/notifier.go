@@ -0,0 +1,92 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Notifier delivers alerts
+type Notifier interface {
+	Notify(alerts []Alert) error
+}
+
+// Webhook posts the alerts as a json object with an alerts array to a URL
+type Webhook struct {
+	URL string `json:"url"`
+	// Headers are added to the request, for example an Authorization header
+	Headers map[string]string `json:"headers"`
+}
+
+// webhookTimeout bounds the time a webhook can take to respond
+const webhookTimeout = 30 * time.Second
+
+// Notify implements Notifier
+func (w Webhook) Notify(alerts []Alert) error {
+	body, err := json.Marshal(map[string]interface{}{"alerts": alerts})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alerts: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Timeout: webhookTimeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to webhook %s: %v", w.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// SMTP mails the alerts
+type SMTP struct {
+	// Addr is the host:port of the SMTP server
+	Addr string   `json:"addr"`
+	From string   `json:"from"`
+	To   []string `json:"to"`
+	// Username and Password authenticate with PLAIN auth if set, the server has to support TLS unless it runs on localhost
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Notify implements Notifier
+func (s SMTP) Notify(alerts []Alert) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address %q: %v", s.Addr, err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if err := smtp.SendMail(s.Addr, auth, s.From, s.To, s.message(alerts)); err != nil {
+		return fmt.Errorf("failed to send mail through %s: %v", s.Addr, err)
+	}
+	return nil
+}
+
+// message returns the mail of the alerts
+func (s SMTP) message(alerts []Alert) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: [gopogh] %d alerts on %s\r\n", len(alerts), alerts[0].Env)
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, a := range alerts {
+		fmt.Fprintf(&b, "%s: %s\r\n", a.Rule, a.Message)
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,140 @@
+package alert
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testAlerts = []Alert{
+	{Rule: "new failures", Type: NewFailure, Env: "Docker_Linux", Commit: "c2", Test: "TestA", Message: "TestA started failing"},
+	{Rule: "flaky", Type: FlakeRate, Env: "Docker_Linux", Commit: "c2", Test: "TestB", Message: "TestB is flaky"},
+}
+
+func TestWebhook(t *testing.T) {
+	var got struct {
+		Alerts []Alert `json:"alerts"`
+	}
+	var auth, contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, contentType = r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode the webhook body: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	w := Webhook{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer TOKEN"}}
+	if err := w.Notify(testAlerts); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if auth != "Bearer TOKEN" || contentType != "application/json" {
+		t.Errorf("got Authorization %q and Content-Type %q", auth, contentType)
+	}
+	if len(got.Alerts) != len(testAlerts) || got.Alerts[0] != testAlerts[0] || got.Alerts[1] != testAlerts[1] {
+		t.Errorf("got alerts %+v, want %+v", got.Alerts, testAlerts)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	if err := (Webhook{URL: srv.URL}).Notify(testAlerts); err == nil {
+		t.Error("Notify succeeded on a failing webhook")
+	}
+}
+
+// smtpStub accepts a single mail and sends what was received on the returned channel
+func smtpStub(t *testing.T) (string, <-chan smtpMail) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	mails := make(chan smtpMail, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var m smtpMail
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost stub")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				m.from = line[len("MAIL FROM:"):]
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				m.to = append(m.to, line[len("RCPT TO:"):])
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					dl, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dl == ".\r\n" {
+						break
+					}
+					data.WriteString(dl)
+				}
+				m.data = data.String()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 bye")
+				mails <- m
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), mails
+}
+
+type smtpMail struct {
+	from string
+	to   []string
+	data string
+}
+
+func TestSMTP(t *testing.T) {
+	addr, mails := smtpStub(t)
+	s := SMTP{Addr: addr, From: "gopogh@example.com", To: []string{"team@example.com", "jane@example.com"}}
+	if err := s.Notify(testAlerts); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	m := <-mails
+	if m.from != "<gopogh@example.com>" {
+		t.Errorf("got sender %q", m.from)
+	}
+	if strings.Join(m.to, ",") != "<team@example.com>,<jane@example.com>" {
+		t.Errorf("got recipients %q", m.to)
+	}
+	for _, want := range []string{"Subject: [gopogh] 2 alerts on Docker_Linux", "new failures: TestA started failing", "flaky: TestB is flaky"} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("mail does not contain %q:\n%s", want, m.data)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrNotSupported is returned when the server does not support a request, for example storing reports without -report_dir
+var ErrNotSupported = errors.New("not supported by the server")
+
+// Client uploads reports to a gopogh server instead of writing to the database directly
+type Client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+// New returns a client for the gopogh server at serverURL authenticating with the project token
+func New(serverURL, token string) *Client {
+	return &Client{
+		url:   strings.TrimSuffix(serverURL, "/"),
+		token: token,
+		http:  &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// UploadSummary sends a json summary produced by report.ShortSummary to the ingest endpoint.
+// It returns the project the run was stored in, the project of the token if the summary has none
+func (c *Client) UploadSummary(summary []byte) (string, error) {
+	resp, err := c.post("/ingest", "application/json", summary)
+	if err != nil {
+		return "", err
+	}
+	var stored struct {
+		Project string `json:"project"`
+	}
+	if err := json.Unmarshal(resp, &stored); err != nil {
+		return "", fmt.Errorf("failed to parse the response of %s: %v", c.url, err)
+	}
+	return stored.Project, nil
+}
+
+// UploadReport sends the generated HTML report of a run to be stored by the server
+func (c *Client) UploadReport(project, env, commit string, html []byte) error {
+	path := "/report/" + url.PathEscape(env) + "/" + url.PathEscape(commit) + "?project=" + url.QueryEscape(project)
+	_, err := c.post(path, "text/html; charset=utf-8", html)
+	return err
+}
+
+// post sends body to path and returns the body of the response
+func (c *Client) post(path, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to %s: %v", c.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotImplemented {
+		return nil, fmt.Errorf("%s %w", path, ErrNotSupported)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("upload to %s failed with status %s: %s", c.url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the response of %s: %v", c.url, err)
+	}
+	return b, nil
+}
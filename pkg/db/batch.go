@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// the number of rows inserted by a statement. postgres round trips are what makes inserting slow, so the batches
+// are as large as they can be while keeping the parameters under its limit of 65535.
+// MySQL has the same limit on the parameters of a prepared statement.
+// sqlite is in process and gets slower to parse long statements, so its batches are small
+const (
+	pgInsertBatchSize     = 500
+	mysqlInsertBatchSize  = 500
+	sqliteInsertBatchSize = 20
+)
+
+// valuesList returns the VALUES list of n rows of cols parameters, $1, $2... if numbered and ? otherwise
+func valuesList(n, cols int, numbered bool) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for j := 0; j < cols; j++ {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			if numbered {
+				fmt.Fprintf(&b, "$%d", i*cols+j+1)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// insertBatches inserts n rows batchSize at a time, with query returning the statement inserting a number of rows
+// and args the parameters of the i-th row
+func insertBatches(ctx context.Context, tx *sql.Tx, n, batchSize int, query func(rows int) string, args func(i int) []interface{}) error {
+	if n == 0 {
+		return nil
+	}
+	var full *sql.Stmt
+	for start := 0; start < n; start += batchSize {
+		end := start + batchSize
+		if end > n {
+			end = n
+		}
+		batchArgs := make([]interface{}, 0, (end-start)*len(args(start)))
+		for i := start; i < end; i++ {
+			batchArgs = append(batchArgs, args(i)...)
+		}
+		var err error
+		if end-start < batchSize {
+			_, err = tx.ExecContext(ctx, query(end-start), batchArgs...)
+		} else {
+			// the full batches share a statement so it is only parsed once
+			if full == nil {
+				if full, err = tx.PrepareContext(ctx, query(batchSize)); err != nil {
+					return fmt.Errorf("failed to prepare SQL insert statement: %v", err)
+				}
+				defer full.Close()
+			}
+			_, err = full.ExecContext(ctx, batchArgs...)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to execute SQL insert: %v", err)
+		}
+	}
+	return nil
+}
+
+// uniqueTestCases drops the test cases repeated in a run, keeping the last one like the upserts of separate rows would.
+// an upsert of several rows fails if two of them conflict with each other
+func uniqueTestCases(rows []models.DBTestCase) []models.DBTestCase {
+	type key struct{ project, commit, env, test string }
+	last := make(map[key]int, len(rows))
+	for i, r := range rows {
+		last[key{r.Project, r.CommitID, r.EnvName, r.TestName}] = i
+	}
+	if len(last) == len(rows) {
+		return rows
+	}
+	unique := make([]models.DBTestCase, 0, len(last))
+	for i, r := range rows {
+		if last[key{r.Project, r.CommitID, r.EnvName, r.TestName}] == i {
+			unique = append(unique, r)
+		}
+	}
+	return unique
+}
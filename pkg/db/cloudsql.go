@@ -34,7 +34,8 @@ func newCloudPostgres(cfg config) (*Postgres, error) {
 		dbx, err = userPassAuth(cfg)
 	}
 
-	return &Postgres{db: dbx, path: cfg.path}, err
+	cfg.configurePool(dbx)
+	return &Postgres{db: dbx, path: cfg.path, timeout: cfg.timeout}, err
 }
 
 func userPassAuth(cfg config) (*sqlx.DB, error) {
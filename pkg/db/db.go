@@ -1,9 +1,12 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/medyagh/gopogh/pkg/models"
 )
 
@@ -14,6 +17,15 @@ type FlagValues struct {
 	Path        string
 	UseCloudSQL bool
 	UseIAMAuth  bool
+	// QueryTimeout bounds the time a call to the database can take, no limit if 0.
+	// Initialize and Prune are not bounded as the migrations and pruning can take long
+	QueryTimeout time.Duration
+	// MaxOpenConns, MaxIdleConns and ConnMaxLifetime configure the connection pool, the database/sql defaults if 0
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// Retries is the number of times Write retries a failed connection or write
+	Retries int
 }
 
 // config is database configuration
@@ -22,21 +34,69 @@ type config struct {
 	path       string
 	host       string
 	useIAMAuth bool
+	// timeout bounds the calls to the database, see FlagValues.QueryTimeout
+	timeout         time.Duration
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
 }
 
-// Datab is the database interface we support
+// configurePool applies the connection pool settings to an opened database
+func (c config) configurePool(database *sqlx.DB) {
+	if database == nil {
+		return
+	}
+	if c.maxOpenConns > 0 {
+		database.SetMaxOpenConns(c.maxOpenConns)
+	}
+	if c.maxIdleConns > 0 {
+		database.SetMaxIdleConns(c.maxIdleConns)
+	}
+	if c.connMaxLifetime > 0 {
+		database.SetConnMaxLifetime(c.connMaxLifetime)
+	}
+}
+
+// withTimeout returns ctx bounded by timeout, not bounded if timeout is 0
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Datab is the database interface we support.
+// The queries stop when ctx is done, the calls are also bounded by FlagValues.QueryTimeout
 type Datab interface {
-	Set(models.DBEnvironmentTest, []models.DBTestCase) error
+	Set(context.Context, models.DBEnvironmentTest, []models.DBTestCase) error
+
+	Initialize(context.Context) error
 
-	Initialize() error
+	GetEnvironmentTestsAndTestCases(ctx context.Context, project string) (map[string]interface{}, error)
 
-	GetEnvironmentTestsAndTestCases() (map[string]interface{}, error)
+	GetEnvCharts(ctx context.Context, f Filter, env string, testsInTop int) (map[string]interface{}, error)
 
-	GetEnvCharts(string, int) (map[string]interface{}, error)
+	GetOverview(ctx context.Context, f Filter) (map[string]interface{}, error)
 
-	GetOverview() (map[string]interface{}, error)
+	GetTestCharts(ctx context.Context, f Filter, env, test string) (map[string]interface{}, error)
 
-	GetTestCharts(string, string) (map[string]interface{}, error)
+	GetTestHistory(ctx context.Context, f Filter, env, test string, n int) (map[string]interface{}, error)
+
+	GetDurationRegressions(ctx context.Context, f Filter, env string, ratio float64) (map[string]interface{}, error)
+
+	GetRunStats(ctx context.Context, run models.DBEnvironmentTest, since time.Time) (*models.DBRunStats, error)
+
+	GetTestFailures(ctx context.Context, f Filter) ([]models.DBTestFailures, error)
+
+	GetOwners(ctx context.Context, f Filter) (map[string]interface{}, error)
+
+	GetDailyTrend(ctx context.Context, f Filter, env, test string) (map[string]interface{}, error)
+
+	GetRuns(ctx context.Context, f Filter, q RowQuery) (*models.DBRunsPage, error)
+
+	GetTestCases(ctx context.Context, f Filter, q RowQuery) (*models.DBTestCasesPage, error)
+
+	Prune(ctx context.Context, before time.Time) (*models.DBPruneResult, error)
 }
 
 // newDB handles which database driver to use and initializes the db
@@ -46,6 +106,8 @@ func newDB(cfg config) (Datab, error) {
 		return newSQLite(cfg)
 	case "postgres":
 		return newPostgres(cfg)
+	case "mysql":
+		return newMySQL(cfg)
 	default:
 		return nil, fmt.Errorf("unknown backend: %q", cfg.dbType)
 	}
@@ -71,6 +133,11 @@ func FromEnv(fv FlagValues) (c Datab, err error) {
 		path:       path,
 		host:       host,
 		useIAMAuth: fv.UseIAMAuth,
+
+		timeout:         fv.QueryTimeout,
+		maxOpenConns:    fv.MaxOpenConns,
+		maxIdleConns:    fv.MaxIdleConns,
+		connMaxLifetime: fv.ConnMaxLifetime,
 	}
 	if fv.UseCloudSQL {
 		c, err = NewCloudSQL(cfg)
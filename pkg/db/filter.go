@@ -0,0 +1,143 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultRange is how far back the charts look when the filter has no From
+	defaultRange = 90 * 24 * time.Hour
+	// defaultWindow is the number of days the recent flake rates are computed over
+	defaultWindow = 15
+	// defaultMinRuns is the number of recent runs a test or environment needs to be ranked
+	defaultMinRuns = 3
+)
+
+// Filter selects the runs of a project the charts are computed from.
+// By default only post-merge runs (runs without a PR) are used, so broken PRs don't count as flakes.
+type Filter struct {
+	Project string
+	// Branch limits the runs to a branch, all branches if empty
+	Branch string
+	// PR limits the runs to a pull request
+	PR string
+	// AllRuns includes the runs of pull requests when PR is empty
+	AllRuns bool
+	// Labels limits the runs to the ones having all of the labels
+	Labels map[string]string
+	// From and To limit the runs to the ones in [From, To), the 90 days before To and up to now if zero
+	From time.Time
+	To   time.Time
+	// Window is the number of days with runs the recent rates are computed over, and compared to the Window days before
+	Window int
+	// MinRuns is the number of runs in the recent window a test or environment needs to be ranked,
+	// so a single failing run does not top the tables
+	MinRuns int
+}
+
+// to returns the end of the time range
+func (f Filter) to() time.Time {
+	if f.To.IsZero() {
+		return time.Now()
+	}
+	return f.To
+}
+
+// from returns the start of the time range
+func (f Filter) from() time.Time {
+	if f.From.IsZero() {
+		return f.to().Add(-defaultRange)
+	}
+	return f.From
+}
+
+// window returns the number of days of the recent window
+func (f Filter) window() int {
+	if f.Window <= 0 {
+		return defaultWindow
+	}
+	return f.Window
+}
+
+// minRuns returns the number of recent runs needed to be ranked
+func (f Filter) minRuns() int {
+	if f.MinRuns <= 0 {
+		return defaultMinRuns
+	}
+	return f.MinRuns
+}
+
+// pgWhere returns the Postgres condition of the filter, numbering its parameters from $n. See pgArgs.
+// It can be used on both db_environment_tests and db_test_cases, labels are looked up in db_environment_tests.
+func (f Filter) pgWhere(n int) string {
+	return fmt.Sprintf(`Project = $%[1]d AND ($%[2]d::text = '' OR Branch = $%[2]d) AND CASE WHEN $%[3]d::text != '' THEN PR = $%[3]d WHEN $%[4]d::boolean THEN TRUE ELSE COALESCE(PR, '') = '' END
+		AND ($%[5]d::jsonb = '{}'::jsonb OR (Project, CommitID, EnvName) IN (SELECT Project, CommitID, EnvName FROM db_environment_tests WHERE Labels @> $%[5]d::jsonb))
+		AND TestTime >= $%[6]d::timestamp AND TestTime < $%[7]d::timestamp`,
+		n, n+1, n+2, n+3, n+4, n+5, n+6)
+}
+
+// rollup returns whether the filter can be answered from the daily rollup tables.
+// The rollups only keep whether a run was post-merge, so the filters on a PR or on labels need the raw rows.
+func (f Filter) rollup() bool {
+	return f.PR == "" && len(f.Labels) == 0
+}
+
+// pgRollupWhere returns the Postgres condition of the filter on the daily rollup tables, numbering its parameters from $n.
+// It takes the same parameters as pgWhere, and matches no rows if the filter cannot be answered from the rollups, see rollup.
+func (f Filter) pgRollupWhere(n int) string {
+	return fmt.Sprintf(`Project = $%[1]d AND ($%[2]d::text = '' OR Branch = $%[2]d) AND CASE WHEN $%[3]d::text != '' THEN FALSE WHEN $%[4]d::boolean THEN TRUE ELSE PostMerge END
+		AND $%[5]d::jsonb = '{}'::jsonb
+		AND Day >= DATE_TRUNC('day', $%[6]d::timestamp) AND Day < $%[7]d::timestamp`,
+		n, n+1, n+2, n+3, n+4, n+5, n+6)
+}
+
+// pgArgs returns the parameters of pgWhere
+func (f Filter) pgArgs() []interface{} {
+	labels := "{}"
+	if len(f.Labels) > 0 {
+		b, _ := json.Marshal(f.Labels)
+		labels = string(b)
+	}
+	return []interface{}{f.Project, f.Branch, f.PR, f.AllRuns, labels, f.from().UTC(), f.to().UTC()}
+}
+
+// args appends the filter parameters to the query specific ones
+func (f Filter) args(queryArgs ...interface{}) []interface{} {
+	return append(queryArgs, f.pgArgs()...)
+}
+
+// mysqlWhere returns the MySQL condition of the filter with named parameters, see mysqlArgs.
+// It can be used on both db_environment_tests and db_test_cases, labels are looked up in db_environment_tests.
+func (f Filter) mysqlWhere() string {
+	return `Project = :project AND (:branch = '' OR Branch = :branch) AND CASE WHEN :pr != '' THEN PR = :pr WHEN :allRuns THEN TRUE ELSE COALESCE(PR, '') = '' END
+		AND (:labels = '{}' OR (Project, CommitID, EnvName) IN (SELECT Project, CommitID, EnvName FROM db_environment_tests WHERE JSON_CONTAINS(Labels, :labels)))
+		AND TestTime >= :from AND TestTime < :to`
+}
+
+// mysqlRollupWhere returns the MySQL condition of the filter on the daily rollup tables.
+// It takes the same parameters as mysqlWhere, and matches no rows if the filter cannot be answered from the rollups, see rollup.
+func (f Filter) mysqlRollupWhere() string {
+	return `Project = :project AND (:branch = '' OR Branch = :branch) AND CASE WHEN :pr != '' THEN FALSE WHEN :allRuns THEN TRUE ELSE PostMerge END
+		AND :labels = '{}'
+		AND Day >= DATE(:from) AND Day < :to`
+}
+
+// mysqlArgs adds the parameters of mysqlWhere to the query specific ones
+func (f Filter) mysqlArgs(queryArgs map[string]interface{}) map[string]interface{} {
+	args := f.pgArgs()
+	named := map[string]interface{}{
+		"project": args[0],
+		"branch":  args[1],
+		"pr":      args[2],
+		"allRuns": args[3],
+		"labels":  args[4],
+		"from":    args[5],
+		"to":      args[6],
+	}
+	for k, v := range queryArgs {
+		named[k] = v
+	}
+	return named
+}
@@ -0,0 +1,108 @@
+package db
+
+import "github.com/medyagh/gopogh/pkg/models"
+
+const (
+	// brokenStreak is the number of consecutive failures on the newest commits after which a test is broken instead of flaky
+	brokenStreak = 3
+
+	classificationBroken = "broken"
+	classificationFlaky  = "flaky"
+	classificationStable = "stable"
+)
+
+// testResult is the result of a test on a commit, in commit order
+type testResult struct {
+	TestName string
+	CommitID string
+	Result   string
+}
+
+// flakiness is the flakiness score and classification of a test
+type flakiness struct {
+	// Score is the percentage of runs that flipped: a failure between two passes, or a commit with both results
+	Score float32
+	// FailStreak is the number of consecutive failures on the newest commits
+	FailStreak int
+	// Classification is broken, flaky or stable
+	Classification string
+}
+
+// scoreFlakiness scores the results of a test ordered from the oldest to the newest commit.
+// Unlike the failure rate, failures on consecutive commits (a commit that broke the test) do not count as flakes.
+func scoreFlakiness(results []testResult) flakiness {
+	if len(results) == 0 {
+		return flakiness{Classification: classificationStable}
+	}
+
+	// the results of the same commit are merged, a commit that both passed and failed flipped
+	type commitResult struct {
+		fail, pass bool
+	}
+	var commits []commitResult
+	for i, r := range results {
+		if i == 0 || r.CommitID != results[i-1].CommitID {
+			commits = append(commits, commitResult{})
+		}
+		c := &commits[len(commits)-1]
+		if r.Result == "fail" {
+			c.fail = true
+		} else {
+			c.pass = true
+		}
+	}
+
+	flips := 0
+	for i, c := range commits {
+		switch {
+		case c.fail && c.pass:
+			flips++
+		case c.fail && i > 0 && i < len(commits)-1 && commits[i-1].pass && commits[i+1].pass:
+			flips++
+		}
+	}
+
+	f := flakiness{Score: float32(flips*10000/len(commits)) / 100}
+	for i := len(commits) - 1; i >= 0 && commits[i].fail && !commits[i].pass; i-- {
+		f.FailStreak++
+	}
+	switch {
+	case f.FailStreak >= brokenStreak:
+		f.Classification = classificationBroken
+	case flips > 0:
+		f.Classification = classificationFlaky
+	default:
+		f.Classification = classificationStable
+	}
+	return f
+}
+
+// setFlakiness scores the tests of the flake table from their results, ordered by test and then from the oldest to the newest commit.
+// The tests without results did not fail, so they are stable
+func setFlakiness(flakeRates []models.DBFlakeRow, results []testResult) {
+	scores := map[string]flakiness{}
+	for i := 0; i < len(results); {
+		j := i
+		for j < len(results) && results[j].TestName == results[i].TestName {
+			j++
+		}
+		scores[results[i].TestName] = scoreFlakiness(results[i:j])
+		i = j
+	}
+	for i := range flakeRates {
+		s, ok := scores[flakeRates[i].TestName]
+		if !ok {
+			s = scoreFlakiness(nil)
+		}
+		flakeRates[i].FlakinessScore, flakeRates[i].FailStreak, flakeRates[i].Classification = s.Score, s.FailStreak, s.Classification
+	}
+}
+
+// flakeTableTests returns the names of the tests of the flake table
+func flakeTableTests(flakeRates []models.DBFlakeRow) []string {
+	names := make([]string, 0, len(flakeRates))
+	for _, row := range flakeRates {
+		names = append(names, row.TestName)
+	}
+	return names
+}
@@ -0,0 +1,24 @@
+package db
+
+import "github.com/medyagh/gopogh/pkg/models"
+
+// reverseHistory reverses the newest first history in place so it goes from the oldest to the newest commit
+func reverseHistory(h []models.DBTestHistory) {
+	for i, j := 0, len(h)-1; i < j; i, j = i+1, j-1 {
+		h[i], h[j] = h[j], h[i]
+	}
+}
+
+// firstFailure returns the commit the test started failing at, the oldest commit of the failures at the end of the history.
+// It returns "" if the test passes on the newest commit, or if it failed on every commit of the history as the
+// commit it started failing at is older than the history.
+func firstFailure(h []models.DBTestHistory) string {
+	i := len(h)
+	for i > 0 && h[i-1].Result == "fail" {
+		i--
+	}
+	if i == 0 || i == len(h) {
+		return ""
+	}
+	return h[i].CommitID
+}
@@ -0,0 +1,942 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// memoryRun is a run stored in memory with its test cases
+type memoryRun struct {
+	env   models.DBEnvironmentTest
+	tests []models.DBTestCase
+}
+
+// runKey identifies a run like the primary key of db_environment_tests
+type runKey struct {
+	project, commit, env string
+}
+
+// Memory keeps the runs in memory and computes the charts from them on each call, the way the SQL backends compute them from
+// their raw rows. It has no daily rollups and does not prune, the summaries it is loaded from are the history.
+// It is filled with Set, gopogh-server -data_dir fills it from the summaries of a directory
+type Memory struct {
+	mu   sync.RWMutex
+	runs map[runKey]*memoryRun
+}
+
+// NewMemory returns an empty in-memory database
+func NewMemory() *Memory {
+	return &Memory{runs: map[runKey]*memoryRun{}}
+}
+
+// Set adds/updates rows to the database
+func (m *Memory) Set(_ context.Context, commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
+	run := &memoryRun{env: commitRow, tests: append([]models.DBTestCase(nil), uniqueTestCases(dbRows)...)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs[runKey{commitRow.Project, commitRow.CommitID, commitRow.EnvName}] = run
+	return nil
+}
+
+// Initialize does nothing, there are no tables to create
+func (m *Memory) Initialize(_ context.Context) error {
+	return nil
+}
+
+// selectRuns returns the runs of the environment, or of every environment if env is empty, matching the filter like pgWhere.
+// The runs are in time order, so are their test cases
+func (m *Memory) selectRuns(f Filter, env string) []*memoryRun {
+	from, to := f.from(), f.to()
+	var runs []*memoryRun
+	for _, r := range m.runs {
+		e := r.env
+		if e.Project != f.Project || (env != "" && e.EnvName != env) || (f.Branch != "" && e.Branch != f.Branch) {
+			continue
+		}
+		if f.PR != "" && e.PR != f.PR || f.PR == "" && !f.AllRuns && e.PR != "" {
+			continue
+		}
+		if e.TestTime.Before(from) || !e.TestTime.Before(to) || !hasLabels(e.Labels, f.Labels) {
+			continue
+		}
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runBefore(runs[i].env, runs[j].env)
+	})
+	return runs
+}
+
+// hasLabels returns whether the labels include all of the wanted ones
+func hasLabels(labels models.Labels, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// runBefore orders the runs by time, commit and environment like the pages of GetRuns in reverse
+func runBefore(a, b models.DBEnvironmentTest) bool {
+	if !a.TestTime.Equal(b.TestTime) {
+		return a.TestTime.Before(b.TestTime)
+	}
+	if a.CommitID != b.CommitID {
+		return a.CommitID < b.CommitID
+	}
+	return a.EnvName < b.EnvName
+}
+
+// testCaseBefore orders the test cases by time, commit, environment and name like the pages of GetTestCases in reverse
+func testCaseBefore(a, b models.DBTestCase) bool {
+	if !a.TestTime.Equal(b.TestTime) {
+		return a.TestTime.Before(b.TestTime)
+	}
+	if a.CommitID != b.CommitID {
+		return a.CommitID < b.CommitID
+	}
+	if a.EnvName != b.EnvName {
+		return a.EnvName < b.EnvName
+	}
+	return a.TestName < b.TestName
+}
+
+// commitOrder returns the runs ordered by the position of their commit in the history, then by time
+func commitOrder(runs []*memoryRun) []*memoryRun {
+	ordered := append([]*memoryRun(nil), runs...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].env.CommitOrder < ordered[j].env.CommitOrder
+	})
+	return ordered
+}
+
+// startOf returns the start of the day, week or month of t, like DATE_TRUNC with weeks starting on Monday
+func startOf(period string, t time.Time) time.Time {
+	y, mo, d := t.UTC().Date()
+	switch period {
+	case "week":
+		day := time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "month":
+		return time.Date(y, mo, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// round2 rounds to 2 decimals like ROUND(x, 2)
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// percentage returns part as a percentage of total, 0 if total is 0
+func percentage(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// point is the test cases of a test, or the runs of an environment, aggregated over a period of a chart
+type point struct {
+	name  string
+	start time.Time
+	runs  int
+	fails int
+	// passes is the number of passing tests of the runs of an environment
+	passes   int
+	duration float64
+	// results are the results of the test cases and commitRuns the runs of the point, in time order
+	results    models.CommitResults
+	commitRuns models.CommitRuns
+}
+
+type pointKey struct {
+	name  string
+	start time.Time
+}
+
+// testPoints aggregates the non-skipped test cases of the runs for which keep returns true by test and period
+func testPoints(runs []*memoryRun, period string, keep func(test string) bool) map[pointKey]*point {
+	points := map[pointKey]*point{}
+	for _, r := range runs {
+		for _, t := range r.tests {
+			if t.Result == "skip" || !keep(t.TestName) {
+				continue
+			}
+			k := pointKey{t.TestName, startOf(period, t.TestTime)}
+			p := points[k]
+			if p == nil {
+				p = &point{name: k.name, start: k.start}
+				points[k] = p
+			}
+			p.runs++
+			if t.Result == "fail" {
+				p.fails++
+			}
+			p.duration += t.Duration
+			p.results = append(p.results, models.CommitResult{Commit: t.CommitID, Result: t.Result, Duration: t.Duration})
+		}
+	}
+	return points
+}
+
+// envPoints aggregates the runs by environment and period
+func envPoints(runs []*memoryRun, period string) map[pointKey]*point {
+	points := map[pointKey]*point{}
+	for _, r := range runs {
+		e := r.env
+		k := pointKey{e.EnvName, startOf(period, e.TestTime)}
+		p := points[k]
+		if p == nil {
+			p = &point{name: k.name, start: k.start}
+			points[k] = p
+		}
+		p.runs++
+		p.fails += e.NumberOfFail
+		p.passes += e.NumberOfPass
+		p.duration += e.TotalDuration
+		p.commitRuns = append(p.commitRuns, models.CommitRun{Commit: e.CommitID, TestCount: e.NumberOfPass + e.NumberOfFail, Duration: e.TotalDuration})
+	}
+	return points
+}
+
+// sortedPoints returns the points oldest first, the points of the same period ordered by name
+func sortedPoints(points map[pointKey]*point) []*point {
+	sorted := make([]*point, 0, len(points))
+	for _, p := range points {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].start.Equal(sorted[j].start) {
+			return sorted[i].start.Before(sorted[j].start)
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	return sorted
+}
+
+// cutoffs returns the start of the recent window, the window most recent days of the points, and the start of the window days before it.
+// They are the zero time if there are fewer days, all of the days are recent then
+func cutoffs(points []*point, window int) (recent, prev time.Time) {
+	var days []time.Time
+	for i := len(points) - 1; i >= 0; i-- {
+		if len(days) == 0 || !days[len(days)-1].Equal(points[i].start) {
+			days = append(days, points[i].start)
+		}
+	}
+	if len(days) > window-1 {
+		recent = days[window-1]
+	}
+	if len(days) > 2*window-1 {
+		prev = days[2*window-1]
+	}
+	return recent, prev
+}
+
+// validateEnv checks the environment has results stored for the project
+func (m *Memory) validateEnv(project, env string) error {
+	for k := range m.runs {
+		if k.project == project && k.env == env {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid environment. Not found in database: %q", env)
+}
+
+// GetEnvironmentTestsAndTestCases writes the newest runs and test cases to a map with the keys environmentTests and testCases
+func (m *Memory) GetEnvironmentTestsAndTestCases(_ context.Context, project string) (map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var runs []*memoryRun
+	for k, r := range m.runs {
+		if k.project == project {
+			runs = append(runs, r)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runBefore(runs[j].env, runs[i].env)
+	})
+	var environmentTests []models.DBEnvironmentTest
+	var testCases []models.DBTestCase
+	for _, r := range runs {
+		if len(environmentTests) < 100 {
+			environmentTests = append(environmentTests, r.env)
+		}
+		for _, t := range r.tests {
+			if len(testCases) < 100 {
+				testCases = append(testCases, t)
+			}
+		}
+		if len(environmentTests) >= 100 && len(testCases) >= 100 {
+			break
+		}
+	}
+	return map[string]interface{}{
+		"environmentTests": environmentTests,
+		"testCases":        testCases,
+	}, nil
+}
+
+// GetRuns returns a page of the stored runs matching the filter and the environment and commit of the query, newest first
+func (m *Memory) GetRuns(_ context.Context, f Filter, q RowQuery) (*models.DBRunsPage, error) {
+	after, ok, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	last := models.DBEnvironmentTest{TestTime: after.TestTime, CommitID: after.CommitID, EnvName: after.EnvName}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var runs []models.DBEnvironmentTest
+	for _, r := range m.selectRuns(f, q.Env) {
+		if (q.Commit == "" || r.env.CommitID == q.Commit) && (!ok || runBefore(r.env, last)) {
+			runs = append(runs, r.env)
+		}
+	}
+	// newest first
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	page := &models.DBRunsPage{Runs: runs}
+	if len(runs) > q.limit() {
+		page.Runs = runs[:q.limit()]
+		last := page.Runs[len(page.Runs)-1]
+		page.NextCursor = cursor{TestTime: last.TestTime, CommitID: last.CommitID, EnvName: last.EnvName}.encode()
+	}
+	return page, nil
+}
+
+// GetTestCases returns a page of the stored test cases matching the filter and the environment, test, result and commit of the query, newest first
+func (m *Memory) GetTestCases(_ context.Context, f Filter, q RowQuery) (*models.DBTestCasesPage, error) {
+	after, ok, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	last := models.DBTestCase{TestTime: after.TestTime, CommitID: after.CommitID, EnvName: after.EnvName, TestName: after.TestName}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var testCases []models.DBTestCase
+	for _, r := range m.selectRuns(f, q.Env) {
+		if q.Commit != "" && r.env.CommitID != q.Commit {
+			continue
+		}
+		for _, t := range r.tests {
+			if (q.Test == "" || t.TestName == q.Test) && (q.Result == "" || t.Result == q.Result) && (!ok || testCaseBefore(t, last)) {
+				testCases = append(testCases, t)
+			}
+		}
+	}
+	sort.Slice(testCases, func(i, j int) bool {
+		return testCaseBefore(testCases[j], testCases[i])
+	})
+	page := &models.DBTestCasesPage{TestCases: testCases}
+	if len(testCases) > q.limit() {
+		page.TestCases = testCases[:q.limit()]
+		last := page.TestCases[len(page.TestCases)-1]
+		page.NextCursor = cursor{TestTime: last.TestTime, CommitID: last.CommitID, EnvName: last.EnvName, TestName: last.TestName}.encode()
+	}
+	return page, nil
+}
+
+// GetTestCharts writes the individual test chart data to a map with the keys flakeByDay, flakeByWeek and flakeByMonth
+func (m *Memory) GetTestCharts(_ context.Context, f Filter, env, test string) (map[string]interface{}, error) {
+	start := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.validateEnv(f.Project, env); err != nil {
+		return nil, err
+	}
+	runs := m.selectRuns(f, env)
+
+	// Groups the test cases by period, calculating flake percentage and average duration and keeping the individual results and durations
+	chart := func(period string) []models.DBTestRateAndDuration {
+		points := sortedPoints(testPoints(runs, period, func(t string) bool { return t == test }))
+		rates := make([]models.DBTestRateAndDuration, 0, len(points))
+		for i := len(points) - 1; i >= 0; i-- {
+			p := points[i]
+			rates = append(rates, models.DBTestRateAndDuration{
+				StartOfDate:     p.start,
+				AvgDuration:     float32(p.duration / float64(p.runs)),
+				FlakePercentage: float32(round2(percentage(p.fails, p.runs))),
+				Commits:         p.results,
+			})
+		}
+		return rates
+	}
+	data := map[string]interface{}{
+		"flakeByDay":   chart("day"),
+		"flakeByWeek":  chart("week"),
+		"flakeByMonth": chart("month"),
+	}
+	log.Printf("\nduration metric: took %f seconds to gather individual test chart data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// GetTestHistory writes the results of the test on the last n commits to a map with the keys history and firstFailure
+func (m *Memory) GetTestHistory(_ context.Context, f Filter, env, test string, n int) (map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.validateEnv(f.Project, env); err != nil {
+		return nil, err
+	}
+	// Orders the runs by the position of their commit in the history, runs without one are ordered by time before the ones with one
+	runs := commitOrder(m.selectRuns(f, env))
+	var history []models.DBTestHistory
+	for i := len(runs) - 1; i >= 0 && len(history) < n; i-- {
+		r := runs[i]
+		for _, t := range r.tests {
+			if t.TestName == test && t.Result != "skip" {
+				history = append(history, models.DBTestHistory{
+					CommitID:     t.CommitID,
+					ParentCommit: r.env.ParentCommit,
+					CommitOrder:  r.env.CommitOrder,
+					TestTime:     t.TestTime,
+					Result:       t.Result,
+					Duration:     float32(t.Duration),
+				})
+			}
+		}
+	}
+	reverseHistory(history)
+	return map[string]interface{}{
+		"history":      history,
+		"firstFailure": firstFailure(history),
+	}, nil
+}
+
+// GetEnvCharts writes the overall environment charts to a map with the keys recentFlakePercentTable, flakeRateByWeek, flakeRateByDay, and countsAndDurations
+func (m *Memory) GetEnvCharts(_ context.Context, f Filter, env string, testsInTop int) (map[string]interface{}, error) {
+	start := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.validateEnv(f.Project, env); err != nil {
+		return nil, err
+	}
+	runs := m.selectRuns(f, env)
+	all := func(string) bool { return true }
+	days := sortedPoints(testPoints(runs, "day", all))
+
+	// Calculates the flake rate, the flake rate growth and the number of runs and fails of each test for the window most recent days
+	// with runs and the window days before that, leaving out the tests with too few recent runs
+	recentCutoff, prevCutoff := cutoffs(days, f.window())
+	type window struct{ runs, fails, prevRuns, prevFails int }
+	windows := map[string]*window{}
+	for _, p := range days {
+		w := windows[p.name]
+		if w == nil {
+			w = &window{}
+			windows[p.name] = w
+		}
+		if !p.start.Before(recentCutoff) {
+			w.runs += p.runs
+			w.fails += p.fails
+		} else if !p.start.Before(prevCutoff) {
+			w.prevRuns += p.runs
+			w.prevFails += p.fails
+		}
+	}
+	flakeRates := []models.DBFlakeRow{}
+	for test, w := range windows {
+		if w.runs < f.minRuns() {
+			continue
+		}
+		recent := round2(percentage(w.fails, w.runs))
+		flakeRates = append(flakeRates, models.DBFlakeRow{
+			TestName:              test,
+			RecentFlakePercentage: float32(recent),
+			GrowthRate:            float32(recent - round2(percentage(w.prevFails, w.prevRuns))),
+			RecentRuns:            w.runs,
+			RecentFails:           w.fails,
+		})
+	}
+	sort.Slice(flakeRates, func(i, j int) bool {
+		a, b := flakeRates[i], flakeRates[j]
+		if a.RecentFlakePercentage != b.RecentFlakePercentage {
+			return a.RecentFlakePercentage > b.RecentFlakePercentage
+		}
+		if a.RecentRuns != b.RecentRuns {
+			return a.RecentRuns > b.RecentRuns
+		}
+		return a.TestName < b.TestName
+	})
+
+	// Scores the results of every test in commit order to tell flaky tests from broken ones
+	results := map[string][]testResult{}
+	for _, r := range commitOrder(runs) {
+		for _, t := range r.tests {
+			if t.Result != "skip" {
+				results[t.TestName] = append(results[t.TestName], testResult{TestName: t.TestName, CommitID: t.CommitID, Result: t.Result})
+			}
+		}
+	}
+	for i := range flakeRates {
+		flakeRates[i].ConfidenceLow, flakeRates[i].ConfidenceHigh = wilsonInterval(flakeRates[i].RecentFails, flakeRates[i].RecentRuns)
+		s := scoreFlakiness(results[flakeRates[i].TestName])
+		flakeRates[i].FlakinessScore, flakeRates[i].FailStreak, flakeRates[i].Classification = s.Score, s.FailStreak, s.Classification
+	}
+
+	// The flake rates and results per day of the top tests
+	top := map[string]bool{}
+	for _, row := range flakeRates {
+		if len(top) >= testsInTop {
+			break
+		}
+		top[row.TestName] = true
+	}
+	var flakeRateByDay []models.DBFlakeBy
+	for i := len(days) - 1; i >= 0; i-- {
+		if p := days[i]; top[p.name] {
+			flakeRateByDay = append(flakeRateByDay, models.DBFlakeBy{TestName: p.name, StartOfDate: p.start, FlakePercentage: float32(percentage(p.fails, p.runs)), Commits: p.results})
+		}
+	}
+
+	// The flake rates per week of the flakiest tests of the most recent week
+	var weekCutoff time.Time
+	for _, p := range days {
+		if w := startOf("week", p.start); w.After(weekCutoff) {
+			weekCutoff = w
+		}
+	}
+	lastWeek := map[string]*window{}
+	for _, p := range days {
+		if p.start.Before(weekCutoff) {
+			continue
+		}
+		w := lastWeek[p.name]
+		if w == nil {
+			w = &window{}
+			lastWeek[p.name] = w
+		}
+		w.runs += p.runs
+		w.fails += p.fails
+	}
+	var flakiest []string
+	for test, w := range lastWeek {
+		if w.runs >= f.minRuns() {
+			flakiest = append(flakiest, test)
+		}
+	}
+	sort.Slice(flakiest, func(i, j int) bool {
+		a, b := lastWeek[flakiest[i]], lastWeek[flakiest[j]]
+		if pa, pb := percentage(a.fails, a.runs), percentage(b.fails, b.runs); pa != pb {
+			return pa > pb
+		}
+		return flakiest[i] < flakiest[j]
+	})
+	if len(flakiest) > testsInTop {
+		flakiest = flakiest[:testsInTop]
+	}
+	topWeek := map[string]bool{}
+	for _, t := range flakiest {
+		topWeek[t] = true
+	}
+	weeks := sortedPoints(testPoints(runs, "week", func(t string) bool { return topWeek[t] }))
+	var flakeRateByWeek []models.DBFlakeBy
+	for i := len(weeks) - 1; i >= 0; i-- {
+		p := weeks[i]
+		flakeRateByWeek = append(flakeRateByWeek, models.DBFlakeBy{TestName: p.name, StartOfDate: p.start, FlakePercentage: float32(round2(percentage(p.fails, p.runs))), Commits: p.results})
+	}
+
+	// The average duration and number of tests of the runs of each day, keeping the number of tests and duration of each run
+	envDays := sortedPoints(envPoints(runs, "day"))
+	var countsAndDurations []models.DBEnvDuration
+	for i := len(envDays) - 1; i >= 0; i-- {
+		p := envDays[i]
+		countsAndDurations = append(countsAndDurations, models.DBEnvDuration{
+			StartOfDate: p.start,
+			TestCount:   float32(float64(p.passes+p.fails) / float64(p.runs)),
+			Duration:    float32(p.duration / float64(p.runs)),
+			Commits:     p.commitRuns,
+		})
+	}
+
+	data := map[string]interface{}{
+		"recentFlakePercentTable": flakeRates,
+		"flakeRateByWeek":         flakeRateByWeek,
+		"flakeRateByDay":          flakeRateByDay,
+		"countsAndDurations":      countsAndDurations,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather env chart data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// GetDurationRegressions writes the tests that got slower on the environment, or on every environment if env is empty, to a map with the key durationRegressions
+func (m *Memory) GetDurationRegressions(_ context.Context, f Filter, env string, ratio float64) (map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return map[string]interface{}{
+		"durationRegressions": m.durationRegressions(f, env, ratio),
+	}, nil
+}
+
+// median returns the median of the values, interpolated like PERCENTILE_CONT(0.5)
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// durationRegressions finds the tests whose median duration in the last window days is ratio times their baseline before,
+// the median of their daily average duration, and the commit the duration shifted at.
+// The days with failed runs are left out of the baseline as those runs may have stopped early or timed out
+func (m *Memory) durationRegressions(f Filter, env string, ratio float64) []models.DBDurationRegression {
+	recentStart := regressionStart(f)
+	runs := commitOrder(m.selectRuns(f, env))
+	type key struct{ env, test string }
+	type day struct {
+		runs, fails int
+		duration    float64
+	}
+	baselineDays, recent := map[key]map[time.Time]*day{}, map[key][]float64{}
+	durations := map[key][]testDuration{}
+	for _, r := range runs {
+		for _, t := range r.tests {
+			if t.Result == "skip" {
+				continue
+			}
+			k := key{t.EnvName, t.TestName}
+			if t.TestTime.Before(recentStart) {
+				d := t.TestTime.UTC().Truncate(24 * time.Hour)
+				if baselineDays[k] == nil {
+					baselineDays[k] = map[time.Time]*day{}
+				}
+				if baselineDays[k][d] == nil {
+					baselineDays[k][d] = &day{}
+				}
+				baselineDays[k][d].runs++
+				baselineDays[k][d].duration += t.Duration
+				if t.Result == "fail" {
+					baselineDays[k][d].fails++
+				}
+			}
+			if t.Result != "pass" {
+				continue
+			}
+			if !t.TestTime.Before(recentStart) {
+				recent[k] = append(recent[k], t.Duration)
+			}
+			durations[k] = append(durations[k], testDuration{CommitID: t.CommitID, Duration: t.Duration})
+		}
+	}
+	regressions := []models.DBDurationRegression{}
+	for k, days := range baselineDays {
+		var b []float64
+		baselineRuns := 0
+		for _, d := range days {
+			if d.fails > 0 {
+				continue
+			}
+			b = append(b, d.duration/float64(d.runs))
+			baselineRuns += d.runs
+		}
+		rc := recent[k]
+		if len(b) == 0 || baselineRuns < f.minRuns() || len(rc) < f.minRuns() {
+			continue
+		}
+		baselineMedian, recentMedian := median(b), median(rc)
+		if recentMedian < baselineMedian*ratio || recentMedian-baselineMedian < minRegressionSeconds {
+			continue
+		}
+		regressions = append(regressions, models.DBDurationRegression{
+			EnvName:        k.env,
+			TestName:       k.test,
+			BaselineMedian: float32(baselineMedian),
+			RecentMedian:   float32(recentMedian),
+			BaselineRuns:   baselineRuns,
+			RecentRuns:     len(rc),
+			FirstCommit:    shiftCommit(durations[k]),
+		})
+	}
+	setRatios(regressions)
+	// the tests that took no time before first, like NULLS FIRST
+	sort.Slice(regressions, func(i, j int) bool {
+		a, b := regressions[i], regressions[j]
+		if (a.BaselineMedian == 0) != (b.BaselineMedian == 0) {
+			return a.BaselineMedian == 0
+		}
+		if a.Ratio != b.Ratio {
+			return a.Ratio > b.Ratio
+		}
+		if a.EnvName != b.EnvName {
+			return a.EnvName < b.EnvName
+		}
+		return a.TestName < b.TestName
+	})
+	return regressions
+}
+
+// GetRunStats returns the stats of the post-merge runs of the environment and branch of run between since and run
+func (m *Memory) GetRunStats(_ context.Context, run models.DBEnvironmentTest, since time.Time) (*models.DBRunStats, error) {
+	stats := &models.DBRunStats{
+		PrevResults: map[string]string{},
+		TestRuns:    map[string]int{},
+		TestFails:   map[string]int{},
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var prev *memoryRun
+	totalFails := 0
+	for _, r := range m.runs {
+		e := r.env
+		if e.Project != run.Project || e.EnvName != run.EnvName || e.Branch != run.Branch || e.PR != "" || !e.TestTime.Before(run.TestTime) {
+			continue
+		}
+		if e.CommitID != run.CommitID && (prev == nil || e.TestTime.After(prev.env.TestTime)) {
+			prev = r
+		}
+		if e.TestTime.Before(since) {
+			continue
+		}
+		stats.EnvRuns++
+		totalFails += e.NumberOfFail
+		for _, t := range r.tests {
+			if t.Result == "skip" {
+				continue
+			}
+			stats.TestRuns[t.TestName]++
+			if t.Result == "fail" {
+				stats.TestFails[t.TestName]++
+			}
+		}
+	}
+	if prev != nil {
+		stats.PrevCommit = prev.env.CommitID
+		for _, t := range prev.tests {
+			stats.PrevResults[t.TestName] = t.Result
+		}
+	}
+	if stats.EnvRuns > 0 {
+		stats.AvgFails = float64(totalFails) / float64(stats.EnvRuns)
+	}
+	return stats, nil
+}
+
+// GetTestFailures returns the number of runs and failures of every test on every environment
+func (m *Memory) GetTestFailures(_ context.Context, f Filter) ([]models.DBTestFailures, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	type key struct{ test, env string }
+	counts := map[key]*models.DBTestFailures{}
+	runs := m.selectRuns(f, "")
+	// newest first so the failed commits are too
+	for i := len(runs) - 1; i >= 0; i-- {
+		for _, t := range runs[i].tests {
+			if t.Result == "skip" {
+				continue
+			}
+			k := key{t.TestName, t.EnvName}
+			c := counts[k]
+			if c == nil {
+				c = &models.DBTestFailures{TestName: t.TestName, EnvName: t.EnvName}
+				counts[k] = c
+			}
+			c.Runs++
+			if t.Result == "fail" {
+				c.Fails++
+				c.FailedCommits = append(c.FailedCommits, t.CommitID)
+			}
+		}
+	}
+	failures := make([]models.DBTestFailures, 0, len(counts))
+	for _, c := range counts {
+		failures = append(failures, *c)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].TestName != failures[j].TestName {
+			return failures[i].TestName < failures[j].TestName
+		}
+		return failures[i].EnvName < failures[j].EnvName
+	})
+	return failures, nil
+}
+
+// GetOwners writes the flake rates and failures of the tests per owner to a map with the keys owners and ownerTests
+func (m *Memory) GetOwners(_ context.Context, f Filter) (map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	type testKey struct{ owner, test, env string }
+	owners := map[string]*models.DBOwnerRow{}
+	ownerTestNames := map[string]map[string]bool{}
+	tests := map[testKey]*models.DBOwnerTest{}
+	for _, r := range m.selectRuns(f, "") {
+		for _, t := range r.tests {
+			if t.Result == "skip" {
+				continue
+			}
+			o := owners[t.Owner]
+			if o == nil {
+				o = &models.DBOwnerRow{Owner: t.Owner}
+				owners[t.Owner] = o
+				ownerTestNames[t.Owner] = map[string]bool{}
+			}
+			k := testKey{t.Owner, t.TestName, t.EnvName}
+			ot := tests[k]
+			if ot == nil {
+				ot = &models.DBOwnerTest{Owner: t.Owner, TestName: t.TestName, EnvName: t.EnvName}
+				tests[k] = ot
+			}
+			ownerTestNames[t.Owner][t.TestName] = true
+			o.Runs++
+			ot.Runs++
+			if t.Result == "fail" {
+				o.Fails++
+				ot.Fails++
+			}
+		}
+	}
+	// Calculates the flake rate of all of the tests of each owner, tests without owners are grouped under ''
+	ownerRows := make([]models.DBOwnerRow, 0, len(owners))
+	for _, o := range owners {
+		o.Tests = len(ownerTestNames[o.Owner])
+		o.FlakePercentage = float32(round2(percentage(o.Fails, o.Runs)))
+		ownerRows = append(ownerRows, *o)
+	}
+	sort.Slice(ownerRows, func(i, j int) bool {
+		if ownerRows[i].FlakePercentage != ownerRows[j].FlakePercentage {
+			return ownerRows[i].FlakePercentage > ownerRows[j].FlakePercentage
+		}
+		return ownerRows[i].Owner < ownerRows[j].Owner
+	})
+	// The flake rate of each failing test of each owner on each environment
+	ownerTests := []models.DBOwnerTest{}
+	for _, ot := range tests {
+		if ot.Fails > 0 {
+			ot.FlakePercentage = float32(round2(percentage(ot.Fails, ot.Runs)))
+			ownerTests = append(ownerTests, *ot)
+		}
+	}
+	sort.Slice(ownerTests, func(i, j int) bool {
+		a, b := ownerTests[i], ownerTests[j]
+		if a.Owner != b.Owner {
+			return a.Owner < b.Owner
+		}
+		if a.FlakePercentage != b.FlakePercentage {
+			return a.FlakePercentage > b.FlakePercentage
+		}
+		if a.TestName != b.TestName {
+			return a.TestName < b.TestName
+		}
+		return a.EnvName < b.EnvName
+	})
+	return map[string]interface{}{
+		"owners":     ownerRows,
+		"ownerTests": ownerTests,
+	}, nil
+}
+
+// Prune is not supported, without daily rollups the pruned runs would be gone from the charts
+func (m *Memory) Prune(_ context.Context, _ time.Time) (*models.DBPruneResult, error) {
+	return nil, nil
+}
+
+// GetDailyTrend writes the daily runs, flake rate and duration of a test on an environment, or of the environment if test is empty,
+// to a map with the key dailyTrend. The fails and flake rate of an environment are the ones of all of its tests.
+func (m *Memory) GetDailyTrend(_ context.Context, f Filter, env, test string) (map[string]interface{}, error) {
+	if f.From.IsZero() {
+		f.From = time.Unix(0, 0)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	runs := m.selectRuns(f, env)
+	var points []*point
+	if test == "" {
+		points = sortedPoints(envPoints(runs, "day"))
+	} else {
+		points = sortedPoints(testPoints(runs, "day", func(t string) bool { return t == test }))
+	}
+	trend := make([]models.DBDailyTrend, 0, len(points))
+	for _, p := range points {
+		flakes := percentage(p.fails, p.runs)
+		if test == "" {
+			flakes = percentage(p.fails, p.fails+p.passes)
+		}
+		trend = append(trend, models.DBDailyTrend{
+			Day:             p.start,
+			Runs:            p.runs,
+			Fails:           p.fails,
+			FlakePercentage: float32(round2(flakes)),
+			AvgDuration:     float32(p.duration / float64(p.runs)),
+		})
+	}
+	return map[string]interface{}{
+		"dailyTrend": trend,
+	}, nil
+}
+
+// GetOverview writes the overview charts to a map with the keys summaryAvgFail, summaryTable and durationRegressions
+func (m *Memory) GetOverview(_ context.Context, f Filter) (map[string]interface{}, error) {
+	start := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	days := sortedPoints(envPoints(m.selectRuns(f, ""), "day"))
+
+	// The average number of failures and average duration per day per environment
+	summaryAvgFail := make([]models.DBSummaryAvgFail, 0, len(days))
+	for _, p := range days {
+		summaryAvgFail = append(summaryAvgFail, models.DBSummaryAvgFail{
+			StartOfDate:    p.start,
+			EnvName:        p.name,
+			AvgFailedTests: float32(float64(p.fails) / float64(p.runs)),
+			AvgDuration:    float32(p.duration / float64(p.runs)),
+		})
+	}
+
+	// The change in the average number of fails of each environment between the window most recent days with runs and the window days before,
+	// leaving out the environments with too few recent runs
+	recentCutoff, prevCutoff := cutoffs(days, f.window())
+	type window struct{ runs, fails, prevRuns, prevFails int }
+	windows := map[string]*window{}
+	for _, p := range days {
+		w := windows[p.name]
+		if w == nil {
+			w = &window{}
+			windows[p.name] = w
+		}
+		if !p.start.Before(recentCutoff) {
+			w.runs += p.runs
+			w.fails += p.fails
+		} else if !p.start.Before(prevCutoff) {
+			w.prevRuns += p.runs
+			w.prevFails += p.fails
+		}
+	}
+	summaryTable := []models.DBSummaryTable{}
+	for env, w := range windows {
+		if w.runs < f.minRuns() {
+			continue
+		}
+		recent := round2(percentage(w.fails, w.runs) / 100)
+		summaryTable = append(summaryTable, models.DBSummaryTable{
+			EnvName:            env,
+			RecentNumberOfFail: float32(recent),
+			Growth:             float32(recent - round2(percentage(w.prevFails, w.prevRuns)/100)),
+			RecentRuns:         w.runs,
+		})
+	}
+	sort.Slice(summaryTable, func(i, j int) bool {
+		if summaryTable[i].RecentNumberOfFail != summaryTable[j].RecentNumberOfFail {
+			return summaryTable[i].RecentNumberOfFail > summaryTable[j].RecentNumberOfFail
+		}
+		return summaryTable[i].EnvName < summaryTable[j].EnvName
+	})
+
+	data := map[string]interface{}{
+		"summaryAvgFail":      summaryAvgFail,
+		"summaryTable":        summaryTable,
+		"durationRegressions": m.durationRegressions(f, "", DefaultRegressionRatio),
+	}
+	log.Printf("\nduration metric: took %f seconds to gather summary data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
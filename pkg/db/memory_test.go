@@ -0,0 +1,261 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+const (
+	testProject = "github.com/kubernetes/minikube/"
+	testEnv     = "Docker_Linux"
+)
+
+// testDay is the nth day of the test runs, the first one is a Monday
+func testDay(n int) time.Time {
+	return time.Date(2026, 1, 4+n, 0, 0, 0, 0, time.UTC)
+}
+
+// testRuns are the results of the tests on the commits c1 to c4, run one per day:
+// TestA failed once between passes, TestB broke on c2, TestC got twice as slow on c3 and TestD is skipped
+var testRuns = map[string][]models.CommitResult{
+	"TestA": {{Result: "pass", Duration: 5}, {Result: "fail", Duration: 5}, {Result: "pass", Duration: 5}, {Result: "pass", Duration: 5}},
+	"TestB": {{Result: "pass", Duration: 3}, {Result: "fail", Duration: 3}, {Result: "fail", Duration: 3}, {Result: "fail", Duration: 3}},
+	"TestC": {{Result: "pass", Duration: 10}, {Result: "pass", Duration: 10}, {Result: "pass", Duration: 20}, {Result: "pass", Duration: 20}},
+	"TestD": {{Result: "skip"}, {Result: "skip"}, {Result: "skip"}, {Result: "skip"}},
+}
+
+// testMemory returns a Memory holding testRuns
+func testMemory(t *testing.T) *Memory {
+	t.Helper()
+	m := NewMemory()
+	for i := 0; i < 4; i++ {
+		commit := "c" + string(rune('1'+i))
+		testTime := testDay(i + 1).Add(10 * time.Hour)
+		env := models.DBEnvironmentTest{Project: testProject, Branch: "master", CommitID: commit, CommitOrder: int64(i + 1), EnvName: testEnv, TestTime: testTime}
+		var tests []models.DBTestCase
+		for _, name := range []string{"TestA", "TestB", "TestC", "TestD"} {
+			r := testRuns[name][i]
+			tests = append(tests, models.DBTestCase{Project: testProject, Branch: "master", CommitID: commit, TestName: name, TestTime: testTime, Result: r.Result, Duration: r.Duration, EnvName: testEnv})
+			switch r.Result {
+			case "pass":
+				env.NumberOfPass++
+			case "fail":
+				env.NumberOfFail++
+			default:
+				env.NumberOfSkip++
+			}
+			env.TotalDuration += r.Duration
+		}
+		if err := m.Set(context.Background(), env, tests); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	return m
+}
+
+// testResults returns the results of the test on the commits from the first to the last, oldest first
+func testResults(test string, first, last int) models.CommitResults {
+	var results models.CommitResults
+	for i := first; i <= last; i++ {
+		r := testRuns[test][i-1]
+		results = append(results, models.CommitResult{Commit: "c" + string(rune('0'+i)), Result: r.Result, Duration: r.Duration})
+	}
+	return results
+}
+
+func testFilter(window int) Filter {
+	return Filter{Project: testProject, To: testDay(5), Window: window, MinRuns: 1}
+}
+
+func TestMemoryTestCharts(t *testing.T) {
+	m := testMemory(t)
+	tests := []struct {
+		test string
+		want map[string]interface{}
+	}{
+		{
+			test: "TestA",
+			want: map[string]interface{}{
+				"flakeByDay": []models.DBTestRateAndDuration{
+					{StartOfDate: testDay(4), AvgDuration: 5, FlakePercentage: 0, Commits: testResults("TestA", 4, 4)},
+					{StartOfDate: testDay(3), AvgDuration: 5, FlakePercentage: 0, Commits: testResults("TestA", 3, 3)},
+					{StartOfDate: testDay(2), AvgDuration: 5, FlakePercentage: 100, Commits: testResults("TestA", 2, 2)},
+					{StartOfDate: testDay(1), AvgDuration: 5, FlakePercentage: 0, Commits: testResults("TestA", 1, 1)},
+				},
+				"flakeByWeek": []models.DBTestRateAndDuration{
+					{StartOfDate: testDay(1), AvgDuration: 5, FlakePercentage: 25, Commits: testResults("TestA", 1, 4)},
+				},
+				"flakeByMonth": []models.DBTestRateAndDuration{
+					{StartOfDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), AvgDuration: 5, FlakePercentage: 25, Commits: testResults("TestA", 1, 4)},
+				},
+			},
+		},
+		{
+			test: "TestC",
+			want: map[string]interface{}{
+				"flakeByDay": []models.DBTestRateAndDuration{
+					{StartOfDate: testDay(4), AvgDuration: 20, FlakePercentage: 0, Commits: testResults("TestC", 4, 4)},
+					{StartOfDate: testDay(3), AvgDuration: 20, FlakePercentage: 0, Commits: testResults("TestC", 3, 3)},
+					{StartOfDate: testDay(2), AvgDuration: 10, FlakePercentage: 0, Commits: testResults("TestC", 2, 2)},
+					{StartOfDate: testDay(1), AvgDuration: 10, FlakePercentage: 0, Commits: testResults("TestC", 1, 1)},
+				},
+				"flakeByWeek": []models.DBTestRateAndDuration{
+					{StartOfDate: testDay(1), AvgDuration: 15, FlakePercentage: 0, Commits: testResults("TestC", 1, 4)},
+				},
+				"flakeByMonth": []models.DBTestRateAndDuration{
+					{StartOfDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), AvgDuration: 15, FlakePercentage: 0, Commits: testResults("TestC", 1, 4)},
+				},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.test, func(t *testing.T) {
+			got, err := m.GetTestCharts(context.Background(), testFilter(2), testEnv, tc.test)
+			if err != nil {
+				t.Fatalf("GetTestCharts: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+	if _, err := m.GetTestCharts(context.Background(), testFilter(2), "KVM_Linux", "TestA"); err == nil {
+		t.Error("GetTestCharts succeeded on an unknown environment")
+	}
+}
+
+func TestMemoryEnvCharts(t *testing.T) {
+	m := testMemory(t)
+	tests := []struct {
+		name   string
+		window int
+		want   []models.DBFlakeRow
+	}{
+		{
+			name:   "two day window",
+			window: 2,
+			want: []models.DBFlakeRow{
+				{TestName: "TestB", RecentFlakePercentage: 100, GrowthRate: 50, RecentRuns: 2, RecentFails: 2, FlakinessScore: 0, FailStreak: 3, Classification: classificationBroken},
+				{TestName: "TestA", RecentFlakePercentage: 0, GrowthRate: -50, RecentRuns: 2, RecentFails: 0, FlakinessScore: 25, FailStreak: 0, Classification: classificationFlaky},
+				{TestName: "TestC", RecentFlakePercentage: 0, GrowthRate: 0, RecentRuns: 2, RecentFails: 0, FlakinessScore: 0, FailStreak: 0, Classification: classificationStable},
+			},
+		},
+		{
+			name:   "one day window",
+			window: 1,
+			want: []models.DBFlakeRow{
+				{TestName: "TestB", RecentFlakePercentage: 100, GrowthRate: 0, RecentRuns: 1, RecentFails: 1, FlakinessScore: 0, FailStreak: 3, Classification: classificationBroken},
+				{TestName: "TestA", RecentFlakePercentage: 0, GrowthRate: 0, RecentRuns: 1, RecentFails: 0, FlakinessScore: 25, FailStreak: 0, Classification: classificationFlaky},
+				{TestName: "TestC", RecentFlakePercentage: 0, GrowthRate: 0, RecentRuns: 1, RecentFails: 0, FlakinessScore: 0, FailStreak: 0, Classification: classificationStable},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := m.GetEnvCharts(context.Background(), testFilter(tc.window), testEnv, 1)
+			if err != nil {
+				t.Fatalf("GetEnvCharts: %v", err)
+			}
+			got := data["recentFlakePercentTable"].([]models.DBFlakeRow)
+			// the confidence interval is the one of wilsonInterval on every backend
+			for i := range got {
+				got[i].ConfidenceLow, got[i].ConfidenceHigh = 0, 0
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	data, err := m.GetEnvCharts(context.Background(), testFilter(2), testEnv, 1)
+	if err != nil {
+		t.Fatalf("GetEnvCharts: %v", err)
+	}
+	wantByDay := []models.DBFlakeBy{
+		{TestName: "TestB", StartOfDate: testDay(4), FlakePercentage: 100, Commits: testResults("TestB", 4, 4)},
+		{TestName: "TestB", StartOfDate: testDay(3), FlakePercentage: 100, Commits: testResults("TestB", 3, 3)},
+		{TestName: "TestB", StartOfDate: testDay(2), FlakePercentage: 100, Commits: testResults("TestB", 2, 2)},
+		{TestName: "TestB", StartOfDate: testDay(1), FlakePercentage: 0, Commits: testResults("TestB", 1, 1)},
+	}
+	if got := data["flakeRateByDay"]; !reflect.DeepEqual(got, wantByDay) {
+		t.Errorf("got flakeRateByDay %+v, want %+v", got, wantByDay)
+	}
+	wantByWeek := []models.DBFlakeBy{
+		{TestName: "TestB", StartOfDate: testDay(1), FlakePercentage: 75, Commits: testResults("TestB", 1, 4)},
+	}
+	if got := data["flakeRateByWeek"]; !reflect.DeepEqual(got, wantByWeek) {
+		t.Errorf("got flakeRateByWeek %+v, want %+v", got, wantByWeek)
+	}
+	wantDurations := []models.DBEnvDuration{
+		{StartOfDate: testDay(4), TestCount: 3, Duration: 28, Commits: models.CommitRuns{{Commit: "c4", TestCount: 3, Duration: 28}}},
+		{StartOfDate: testDay(3), TestCount: 3, Duration: 28, Commits: models.CommitRuns{{Commit: "c3", TestCount: 3, Duration: 28}}},
+		{StartOfDate: testDay(2), TestCount: 3, Duration: 18, Commits: models.CommitRuns{{Commit: "c2", TestCount: 3, Duration: 18}}},
+		{StartOfDate: testDay(1), TestCount: 3, Duration: 18, Commits: models.CommitRuns{{Commit: "c1", TestCount: 3, Duration: 18}}},
+	}
+	if got := data["countsAndDurations"]; !reflect.DeepEqual(got, wantDurations) {
+		t.Errorf("got countsAndDurations %+v, want %+v", got, wantDurations)
+	}
+}
+
+func TestMemoryDurationRegressions(t *testing.T) {
+	m := testMemory(t)
+	data, err := m.GetDurationRegressions(context.Background(), testFilter(2), "", DefaultRegressionRatio)
+	if err != nil {
+		t.Fatalf("GetDurationRegressions: %v", err)
+	}
+	// TestA is left out as its baseline is the first day only, the second one had a failure
+	want := []models.DBDurationRegression{
+		{EnvName: testEnv, TestName: "TestC", BaselineMedian: 10, RecentMedian: 20, Ratio: 2, BaselineRuns: 2, RecentRuns: 2, FirstCommit: "c3"},
+	}
+	if got := data["durationRegressions"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestScoreFlakiness(t *testing.T) {
+	results := func(commitResults ...string) []testResult {
+		var r []testResult
+		for i := 0; i < len(commitResults); i += 2 {
+			r = append(r, testResult{TestName: "TestA", CommitID: commitResults[i], Result: commitResults[i+1]})
+		}
+		return r
+	}
+	tests := []struct {
+		name    string
+		results []testResult
+		want    flakiness
+	}{
+		{"no results", nil, flakiness{Classification: classificationStable}},
+		{"failure between passes", results("c1", "pass", "c2", "fail", "c3", "pass"), flakiness{Score: 33.33, Classification: classificationFlaky}},
+		{"both results on a commit", results("c1", "pass", "c1", "fail", "c2", "pass"), flakiness{Score: 50, Classification: classificationFlaky}},
+		{"failures on consecutive commits", results("c1", "pass", "c2", "fail", "c3", "fail", "c4", "pass"), flakiness{Classification: classificationStable}},
+		{"failing on the newest commit", results("c1", "fail", "c2", "pass", "c3", "fail"), flakiness{FailStreak: 1, Classification: classificationStable}},
+		{"broken", results("c1", "pass", "c2", "fail", "c3", "fail", "c4", "fail"), flakiness{FailStreak: 3, Classification: classificationBroken}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := scoreFlakiness(tc.results); got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMemoryPrune(t *testing.T) {
+	m := testMemory(t)
+	pruned, err := m.Prune(context.Background(), testDay(5))
+	if err != nil || pruned != nil {
+		t.Errorf("got %+v, %v, want pruning to be unsupported", pruned, err)
+	}
+	data, err := m.GetTestCharts(context.Background(), testFilter(2), testEnv, "TestA")
+	if err != nil {
+		t.Fatalf("GetTestCharts: %v", err)
+	}
+	if days := data["flakeByDay"].([]models.DBTestRateAndDuration); len(days) != 4 {
+		t.Errorf("got %d days after pruning, want the 4 days", len(days))
+	}
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migration is a schema change applied once per database, after the tables are created
+type migration []string
+
+var createMigrationsTableSQL = `
+	CREATE TABLE IF NOT EXISTS db_schema_migrations (
+		Version INTEGER PRIMARY KEY
+	);
+`
+
+// migrate applies the migrations that have not yet been applied to the database, in order.
+// lock is executed at the start of each migration transaction to serialize concurrent gopogh runs.
+func migrate(ctx context.Context, db *sqlx.DB, migrations []migration, lock string) error {
+	if _, err := db.ExecContext(ctx, createMigrationsTableSQL); err != nil {
+		return fmt.Errorf("failed to initialize schema migrations table: %v", err)
+	}
+	for i, m := range migrations {
+		version := i + 1
+		if err := applyMigration(ctx, db, version, m, lock); err != nil {
+			return fmt.Errorf("failed to apply schema migration %d: %v", version, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sqlx.DB, version int, m migration, lock string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck // rollback after commit is a no-op
+
+	if lock != "" {
+		if _, err := tx.ExecContext(ctx, lock); err != nil {
+			return err
+		}
+	}
+	var applied int
+	if err := tx.GetContext(ctx, &applied, fmt.Sprintf("SELECT COUNT(*) FROM db_schema_migrations WHERE Version = %d", version)); err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+	for _, stmt := range m {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO db_schema_migrations (Version) VALUES (%d)", version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
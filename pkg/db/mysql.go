@@ -0,0 +1,1158 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// the key columns are VARCHARs so they can be indexed, sized so the primary keys fit in the 3072 bytes of an InnoDB index with utf8mb4
+var mysqlEnvTableSchema = `
+	CREATE TABLE IF NOT EXISTS db_environment_tests (
+		Project VARCHAR(100) NOT NULL DEFAULT '',
+		CommitID VARCHAR(100) NOT NULL,
+		EnvName VARCHAR(100) NOT NULL,
+		Branch VARCHAR(100) NOT NULL DEFAULT '',
+		PR VARCHAR(100) NOT NULL DEFAULT '',
+		Details TEXT NOT NULL,
+		ParentCommit VARCHAR(100) NOT NULL DEFAULT '',
+		CommitOrder BIGINT NOT NULL DEFAULT 0,
+		GopoghTime DATETIME(6),
+		TestTime DATETIME(6),
+		NumberOfFail INTEGER,
+		NumberOfPass INTEGER,
+		NumberOfSkip INTEGER,
+		TotalDuration DOUBLE,
+		GopoghVersion VARCHAR(100) NOT NULL DEFAULT '',
+		GoVersion VARCHAR(100) NOT NULL DEFAULT '',
+		GOOS VARCHAR(100) NOT NULL DEFAULT '',
+		GOARCH VARCHAR(100) NOT NULL DEFAULT '',
+		Hostname VARCHAR(255) NOT NULL DEFAULT '',
+		` + "`Trigger`" + ` VARCHAR(100) NOT NULL DEFAULT '',
+		Labels JSON NOT NULL,
+		PRIMARY KEY (Project, CommitID, EnvName),
+		INDEX (Project, TestTime)
+	);
+`
+var mysqlTestCasesTableSchema = `
+	CREATE TABLE IF NOT EXISTS db_test_cases (
+		Project VARCHAR(100) NOT NULL DEFAULT '',
+		CommitID VARCHAR(100) NOT NULL,
+		EnvName VARCHAR(100) NOT NULL,
+		TestName VARCHAR(400) NOT NULL,
+		Branch VARCHAR(100) NOT NULL DEFAULT '',
+		PR VARCHAR(100) NOT NULL DEFAULT '',
+		Owner VARCHAR(255) NOT NULL DEFAULT '',
+		Result VARCHAR(20),
+		TestTime DATETIME(6),
+		Duration DOUBLE,
+		PRIMARY KEY (Project, CommitID, EnvName, TestName),
+		INDEX (Project, EnvName, TestTime)
+	);
+`
+var mysqlTestCasesDailyTableSchema = `
+	CREATE TABLE IF NOT EXISTS db_test_cases_daily (
+		Project VARCHAR(100) NOT NULL,
+		EnvName VARCHAR(100) NOT NULL,
+		Branch VARCHAR(100) NOT NULL,
+		PostMerge BOOLEAN NOT NULL,
+		TestName VARCHAR(400) NOT NULL,
+		Day DATE NOT NULL,
+		Runs INTEGER NOT NULL,
+		Fails INTEGER NOT NULL,
+		Skips INTEGER NOT NULL,
+		TotalDuration DOUBLE NOT NULL,
+		PRIMARY KEY (Project, EnvName, Branch, PostMerge, TestName, Day)
+	);
+`
+var mysqlEnvDailyTableSchema = `
+	CREATE TABLE IF NOT EXISTS db_environment_tests_daily (
+		Project VARCHAR(100) NOT NULL,
+		EnvName VARCHAR(100) NOT NULL,
+		Branch VARCHAR(100) NOT NULL,
+		PostMerge BOOLEAN NOT NULL,
+		Day DATE NOT NULL,
+		Runs INTEGER NOT NULL,
+		NumberOfFail INTEGER NOT NULL,
+		NumberOfPass INTEGER NOT NULL,
+		NumberOfSkip INTEGER NOT NULL,
+		TotalDuration DOUBLE NOT NULL,
+		PRIMARY KEY (Project, EnvName, Branch, PostMerge, Day)
+	);
+`
+
+// mysqlMigrations are the schema changes since the tables were first created, the MySQL tables started out with the current schema
+var mysqlMigrations = []migration{}
+
+// mysqlRollupTestCasesSQL adds the test cases matching the condition (%[2]s) to the daily rollups, multiplied by %[1]d
+// so a run can be taken out of them before it is replaced
+const mysqlRollupTestCasesSQL = `
+	INSERT INTO db_test_cases_daily (Project, EnvName, Branch, PostMerge, TestName, Day, Runs, Fails, Skips, TotalDuration)
+	SELECT Project, EnvName, Branch, COALESCE(PR, '') = '', TestName, DATE(TestTime),
+	%[1]d * COUNT(CASE WHEN Result != 'skip' THEN 1 END), %[1]d * COUNT(CASE WHEN Result = 'fail' THEN 1 END), %[1]d * COUNT(CASE WHEN Result = 'skip' THEN 1 END),
+	%[1]d * COALESCE(SUM(CASE WHEN Result != 'skip' THEN Duration END), 0)
+	FROM db_test_cases
+	WHERE TestTime IS NOT NULL AND (%[2]s)
+	GROUP BY 1, 2, 3, 4, 5, 6
+	ON DUPLICATE KEY UPDATE Runs = db_test_cases_daily.Runs + VALUES(Runs), Fails = db_test_cases_daily.Fails + VALUES(Fails),
+		Skips = db_test_cases_daily.Skips + VALUES(Skips), TotalDuration = db_test_cases_daily.TotalDuration + VALUES(TotalDuration)
+`
+
+// mysqlRollupEnvironmentTestsSQL adds the runs matching the condition (%[2]s) to the daily rollups, multiplied by %[1]d
+const mysqlRollupEnvironmentTestsSQL = `
+	INSERT INTO db_environment_tests_daily (Project, EnvName, Branch, PostMerge, Day, Runs, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration)
+	SELECT Project, EnvName, Branch, PR = '', DATE(TestTime),
+	%[1]d * COUNT(*), %[1]d * SUM(NumberOfFail), %[1]d * SUM(NumberOfPass), %[1]d * SUM(NumberOfSkip), %[1]d * SUM(TotalDuration)
+	FROM db_environment_tests
+	WHERE TestTime IS NOT NULL AND (%[2]s)
+	GROUP BY 1, 2, 3, 4, 5
+	ON DUPLICATE KEY UPDATE Runs = db_environment_tests_daily.Runs + VALUES(Runs),
+		NumberOfFail = db_environment_tests_daily.NumberOfFail + VALUES(NumberOfFail), NumberOfPass = db_environment_tests_daily.NumberOfPass + VALUES(NumberOfPass),
+		NumberOfSkip = db_environment_tests_daily.NumberOfSkip + VALUES(NumberOfSkip), TotalDuration = db_environment_tests_daily.TotalDuration + VALUES(TotalDuration)
+`
+
+// mysqlRunCondition is the condition of the rows of a run, taking the project, commit and environment as parameters
+const mysqlRunCondition = "Project = ? AND CommitID = ? AND EnvName = ?"
+
+// mysqlGroupConcatMaxLen is the length the aggregated commits of a chart point can have,
+// GROUP_CONCAT cuts its result at 1024 bytes by default
+const mysqlGroupConcatMaxLen = "16777216"
+
+// MySQL is the MySQL or MariaDB database. It needs MySQL 8 or MariaDB 10.5 for the CTEs, window functions and JSON functions of the queries
+type MySQL struct {
+	db      *sqlx.DB
+	path    string
+	timeout time.Duration
+}
+
+// Set adds/updates rows to the database
+func (m *MySQL) Set(ctx context.Context, commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	tx, err := m.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create SQL transaction: %v", err)
+	}
+
+	var rollbackError error
+	defer func() {
+		if rErr := tx.Rollback(); rErr != nil {
+			rollbackError = fmt.Errorf("error occurred during rollback: %v", rErr)
+		}
+	}()
+
+	// takes the run out of the daily rollups in case it is being replaced, it is added back once stored
+	if err := mysqlRollupRun(ctx, tx, -1, commitRow); err != nil {
+		return err
+	}
+
+	// a row repeated in a statement updates the one inserted before it, so unlike postgres the test cases need not be unique
+	insertTestCases := func(rows int) string {
+		return `
+		INSERT INTO db_test_cases (PR, CommitID, EnvName, TestName, Result, TestTime, Duration, Project, Branch, Owner)
+		VALUES ` + valuesList(rows, 10, false) + `
+		ON DUPLICATE KEY UPDATE PR = VALUES(PR), Result = VALUES(Result), TestTime = VALUES(TestTime), Duration = VALUES(Duration), Branch = VALUES(Branch), Owner = VALUES(Owner)
+	`
+	}
+	err = insertBatches(ctx, tx, len(dbRows), mysqlInsertBatchSize, insertTestCases, func(i int) []interface{} {
+		r := dbRows[i]
+		return []interface{}{r.PR, r.CommitID, r.EnvName, r.TestName, r.Result, r.TestTime, r.Duration, r.Project, r.Branch, r.Owner}
+	})
+	if err != nil {
+		return err
+	}
+
+	sqlInsert := `
+		INSERT INTO db_environment_tests (CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, Project, Branch, PR, GopoghVersion, GoVersion, GOOS, GOARCH, Hostname, ` + "`Trigger`" + `, Labels, Details, ParentCommit, CommitOrder)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE GopoghTime = VALUES(GopoghTime), TestTime = VALUES(TestTime), NumberOfFail = VALUES(NumberOfFail), NumberOfPass = VALUES(NumberOfPass),
+		NumberOfSkip = VALUES(NumberOfSkip), TotalDuration = VALUES(TotalDuration), Branch = VALUES(Branch), PR = VALUES(PR), GopoghVersion = VALUES(GopoghVersion),
+		GoVersion = VALUES(GoVersion), GOOS = VALUES(GOOS), GOARCH = VALUES(GOARCH), Hostname = VALUES(Hostname), ` + "`Trigger` = VALUES(`Trigger`)" + `,
+		Labels = VALUES(Labels), Details = VALUES(Details), ParentCommit = VALUES(ParentCommit), CommitOrder = VALUES(CommitOrder)
+		`
+	_, err = tx.ExecContext(ctx, sqlInsert, commitRow.CommitID, commitRow.EnvName, commitRow.GopoghTime, commitRow.TestTime, commitRow.NumberOfFail, commitRow.NumberOfPass, commitRow.NumberOfSkip, commitRow.TotalDuration, commitRow.Project, commitRow.Branch, commitRow.PR, commitRow.GopoghVersion, commitRow.GoVersion, commitRow.GOOS, commitRow.GOARCH, commitRow.Hostname, commitRow.Trigger, commitRow.Labels, commitRow.Details, commitRow.ParentCommit, commitRow.CommitOrder)
+	if err != nil {
+		return fmt.Errorf("failed to execute SQL insert: %v", err)
+	}
+	if err := mysqlRollupRun(ctx, tx, 1, commitRow); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM db_test_cases_daily WHERE Project = ? AND EnvName = ? AND Runs = 0 AND Skips = 0`, commitRow.Project, commitRow.EnvName); err != nil {
+		return fmt.Errorf("failed to delete empty test case rollups: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM db_environment_tests_daily WHERE Project = ? AND EnvName = ? AND Runs = 0`, commitRow.Project, commitRow.EnvName); err != nil {
+		return fmt.Errorf("failed to delete empty environment test rollups: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit SQL insert transaction: %v", err)
+	}
+	return rollbackError
+}
+
+// mysqlRollupRun adds the stored rows of a run to the daily rollups, multiplied by sign
+func mysqlRollupRun(ctx context.Context, tx *sql.Tx, sign int, run models.DBEnvironmentTest) error {
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(mysqlRollupTestCasesSQL, sign, mysqlRunCondition), run.Project, run.CommitID, run.EnvName); err != nil {
+		return fmt.Errorf("failed to roll up test cases: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(mysqlRollupEnvironmentTestsSQL, sign, mysqlRunCondition), run.Project, run.CommitID, run.EnvName); err != nil {
+		return fmt.Errorf("failed to roll up environment tests: %v", err)
+	}
+	return nil
+}
+
+// newMySQL opens the database returning a MySQL database struct instance
+func newMySQL(cfg config) (*MySQL, error) {
+	path, err := mysqlDSN(cfg)
+	if err != nil {
+		return nil, err
+	}
+	database, err := sqlx.Connect("mysql", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %v", err)
+	}
+	// MySQL returns the column names as they are written in the schema and the queries, which are the names of the model fields
+	database.MapperFunc(func(s string) string { return s })
+	cfg.configurePool(database)
+	m := &MySQL{
+		db:      database,
+		path:    path,
+		timeout: cfg.timeout,
+	}
+	return m, nil
+}
+
+// mysqlDSN returns the data source name of the database from the path, a DSN in the form of 'user:password@/dbname',
+// connecting over TCP to the host if set
+func mysqlDSN(cfg config) (string, error) {
+	c, err := mysql.ParseDSN(cfg.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse MySQL DSN: %v", err)
+	}
+	if cfg.host != "" {
+		c.Net = "tcp"
+		c.Addr = cfg.host
+	}
+	// the times are stored in UTC and scanned into time.Time
+	c.ParseTime = true
+	c.Loc = time.UTC
+	if c.Params == nil {
+		c.Params = map[string]string{}
+	}
+	if _, ok := c.Params["group_concat_max_len"]; !ok {
+		c.Params["group_concat_max_len"] = mysqlGroupConcatMaxLen
+	}
+	return c.FormatDSN(), nil
+}
+
+// Initialize creates the tables within the MySQL database
+func (m *MySQL) Initialize(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, mysqlEnvTableSchema); err != nil {
+		return fmt.Errorf("failed to initialize environment tests table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, mysqlTestCasesTableSchema); err != nil {
+		return fmt.Errorf("failed to initialize test cases table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, mysqlTestCasesDailyTableSchema); err != nil {
+		return fmt.Errorf("failed to initialize daily test cases table: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, mysqlEnvDailyTableSchema); err != nil {
+		return fmt.Errorf("failed to initialize daily environment tests table: %v", err)
+	}
+	// MySQL commits schema changes right away so they cannot be serialized by a transaction lock
+	return migrate(ctx, m.db, mysqlMigrations, "")
+}
+
+// selectNamed runs a query with :name parameters taken from arg, the slices of arg are expanded for IN lists
+func (m *MySQL) selectNamed(ctx context.Context, dest interface{}, query string, arg map[string]interface{}) error {
+	q, args, err := sqlx.Named(query, arg)
+	if err != nil {
+		return err
+	}
+	q, args, err = sqlx.In(q, args...)
+	if err != nil {
+		return err
+	}
+	return m.db.SelectContext(ctx, dest, q, args...)
+}
+
+// GetEnvironmentTestsAndTestCases writes the database tables to a map with the keys environmentTests and testCases
+func (m *MySQL) GetEnvironmentTestsAndTestCases(ctx context.Context, project string) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+
+	var environmentTests []models.DBEnvironmentTest
+	var testCases []models.DBTestCase
+
+	err := m.db.SelectContext(ctx, &environmentTests, "SELECT * FROM db_environment_tests WHERE Project = ? ORDER BY TestTime DESC LIMIT 100", project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environment tests: %v", err)
+	}
+
+	err = m.db.SelectContext(ctx, &testCases, "SELECT * FROM db_test_cases WHERE Project = ? ORDER BY TestTime DESC LIMIT 100", project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+	}
+	data := map[string]interface{}{
+		"environmentTests": environmentTests,
+		"testCases":        testCases,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather all table data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// GetRuns returns a page of the stored runs matching the filter and the environment and commit of the query, newest first
+func (m *MySQL) GetRuns(ctx context.Context, f Filter, q RowQuery) (*models.DBRunsPage, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	after, ok, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	sqlQuery := `
+	SELECT * FROM db_environment_tests
+	WHERE (:env = '' OR EnvName = :env) AND (:commit = '' OR CommitID = :commit)
+	AND (NOT :after OR (TestTime, CommitID, EnvName) < (:afterTime, :afterCommit, :afterEnv))
+	AND ` + f.mysqlWhere() + `
+	ORDER BY TestTime DESC, CommitID DESC, EnvName DESC
+	LIMIT :limit
+	`
+	var runs []models.DBEnvironmentTest
+	// one more row than the page tells whether there is a next page
+	err = m.selectNamed(ctx, &runs, sqlQuery, f.mysqlArgs(map[string]interface{}{
+		"env": q.Env, "commit": q.Commit, "after": ok, "afterTime": after.TestTime.UTC(), "afterCommit": after.CommitID, "afterEnv": after.EnvName, "limit": q.limit() + 1,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for runs: %v", err)
+	}
+	page := &models.DBRunsPage{Runs: runs}
+	if len(runs) > q.limit() {
+		page.Runs = runs[:q.limit()]
+		last := page.Runs[len(page.Runs)-1]
+		page.NextCursor = cursor{TestTime: last.TestTime, CommitID: last.CommitID, EnvName: last.EnvName}.encode()
+	}
+	return page, nil
+}
+
+// GetTestCases returns a page of the stored test cases matching the filter and the environment, test, result and commit of the query, newest first
+func (m *MySQL) GetTestCases(ctx context.Context, f Filter, q RowQuery) (*models.DBTestCasesPage, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	after, ok, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	sqlQuery := `
+	SELECT Project, Branch, PR, CommitID, TestName, Owner, TestTime, Result, Duration, EnvName
+	FROM db_test_cases
+	WHERE (:env = '' OR EnvName = :env) AND (:test = '' OR TestName = :test) AND (:result = '' OR Result = :result) AND (:commit = '' OR CommitID = :commit)
+	AND (NOT :after OR (TestTime, CommitID, EnvName, TestName) < (:afterTime, :afterCommit, :afterEnv, :afterTest))
+	AND ` + f.mysqlWhere() + `
+	ORDER BY TestTime DESC, CommitID DESC, EnvName DESC, TestName DESC
+	LIMIT :limit
+	`
+	var testCases []models.DBTestCase
+	err = m.selectNamed(ctx, &testCases, sqlQuery, f.mysqlArgs(map[string]interface{}{
+		"env": q.Env, "test": q.Test, "result": q.Result, "commit": q.Commit,
+		"after": ok, "afterTime": after.TestTime.UTC(), "afterCommit": after.CommitID, "afterEnv": after.EnvName, "afterTest": after.TestName, "limit": q.limit() + 1,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+	}
+	page := &models.DBTestCasesPage{TestCases: testCases}
+	if len(testCases) > q.limit() {
+		page.TestCases = testCases[:q.limit()]
+		last := page.TestCases[len(page.TestCases)-1]
+		page.NextCursor = cursor{TestTime: last.TestTime, CommitID: last.CommitID, EnvName: last.EnvName, TestName: last.TestName}.encode()
+	}
+	return page, nil
+}
+
+// mysqlLastnData is the CTE of the recent non-skipped test cases of the environment (:env) matching the filter
+func mysqlLastnData(f Filter) string {
+	return `
+	lastn_data AS (
+		SELECT * FROM db_test_cases
+		WHERE Result != 'skip' AND EnvName = :env AND ` + f.mysqlWhere() + `
+	)`
+}
+
+// mysqlLastnEnvData is the CTE of the recent runs of the environment (:env) matching the filter
+func mysqlLastnEnvData(f Filter) string {
+	return `
+	lastn_env_data AS (
+		SELECT *
+		FROM db_environment_tests
+		WHERE EnvName = :env AND ` + f.mysqlWhere() + `
+	)`
+}
+
+// mysqlTestDaysData is the CTE of the daily runs, fails and durations of the non-skipped test cases of the environment (:env)
+// matching the filter. It reads the daily rollups unless the filter needs the raw rows
+func mysqlTestDaysData(f Filter) string {
+	if f.rollup() {
+		return `
+	test_days AS (
+		SELECT TestName, Day, SUM(Runs) AS Runs, SUM(Fails) AS Fails, SUM(TotalDuration) AS TotalDuration
+		FROM db_test_cases_daily
+		WHERE EnvName = :env AND ` + f.mysqlRollupWhere() + `
+		GROUP BY TestName, Day
+		HAVING SUM(Runs) > 0
+	)`
+	}
+	return `
+	test_days AS (
+		SELECT TestName, DATE(TestTime) AS Day, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails, SUM(Duration) AS TotalDuration
+		FROM db_test_cases
+		WHERE Result != 'skip' AND EnvName = :env AND ` + f.mysqlWhere() + `
+		GROUP BY TestName, Day
+	)`
+}
+
+// mysqlBaselineDaysData is the CTE of the daily run count and average duration of the tests on the environment (:env), or on every environment if :env is empty,
+// on the days before the recent window (:recentStart) without failed runs matching the filter. It reads the daily rollups unless the filter needs the raw rows
+func mysqlBaselineDaysData(f Filter) string {
+	if f.rollup() {
+		return `
+	baseline_days AS (
+		SELECT EnvName, TestName, Day, SUM(Runs) AS Runs, SUM(TotalDuration) / SUM(Runs) AS Duration
+		FROM db_test_cases_daily
+		WHERE (:env = '' OR EnvName = :env) AND Day < :recentStart AND ` + f.mysqlRollupWhere() + `
+		GROUP BY EnvName, TestName, Day
+		HAVING SUM(Runs) > 0 AND SUM(Fails) = 0
+	)`
+	}
+	return `
+	baseline_days AS (
+		SELECT EnvName, TestName, DATE(TestTime) AS Day, COUNT(*) AS Runs, AVG(Duration) AS Duration
+		FROM db_test_cases
+		WHERE Result != 'skip' AND (:env = '' OR EnvName = :env) AND TestTime < :recentStart AND ` + f.mysqlWhere() + `
+		GROUP BY EnvName, TestName, Day
+		HAVING COUNT(CASE WHEN Result = 'fail' THEN 1 END) = 0
+	)`
+}
+
+// mysqlEnvDaysData is the CTE of the daily runs, fails, passes and durations of the environment (:env), or of every environment if :env is empty,
+// matching the filter. It reads the daily rollups unless the filter needs the raw rows
+func mysqlEnvDaysData(f Filter) string {
+	if f.rollup() {
+		return `
+	env_days AS (
+		SELECT EnvName, Day, SUM(Runs) AS Runs, SUM(NumberOfFail) AS NumberOfFail, SUM(NumberOfPass) AS NumberOfPass, SUM(TotalDuration) AS TotalDuration
+		FROM db_environment_tests_daily
+		WHERE (:env = '' OR EnvName = :env) AND ` + f.mysqlRollupWhere() + `
+		GROUP BY EnvName, Day
+		HAVING SUM(Runs) > 0
+	)`
+	}
+	return `
+	env_days AS (
+		SELECT EnvName, DATE(TestTime) AS Day, COUNT(*) AS Runs, SUM(NumberOfFail) AS NumberOfFail, SUM(NumberOfPass) AS NumberOfPass, SUM(TotalDuration) AS TotalDuration
+		FROM db_environment_tests
+		WHERE (:env = '' OR EnvName = :env) AND ` + f.mysqlWhere() + `
+		GROUP BY EnvName, Day
+	)`
+}
+
+// mysqlTrunc returns the date of the start of the day, week or month of the time expression, like DATE_TRUNC with weeks starting on Monday
+func mysqlTrunc(period, expr string) string {
+	switch period {
+	case "week":
+		return "DATE_SUB(DATE(" + expr + "), INTERVAL WEEKDAY(" + expr + ") DAY)"
+	case "month":
+		return "CAST(DATE_FORMAT(" + expr + ", '%Y-%m-01') AS DATE)"
+	default:
+		return "DATE(" + expr + ")"
+	}
+}
+
+// mysqlJSONArray returns the json array of the objects of the key value pairs (like JSON_OBJECT) of the rows of a group, ordered by TestTime.
+// JSON_ARRAYAGG cannot be ordered so the objects are concatenated instead
+func mysqlJSONArray(keyValues string) string {
+	return "CONCAT('[', GROUP_CONCAT(JSON_OBJECT(" + keyValues + ") ORDER BY TestTime SEPARATOR ','), ']')"
+}
+
+// validateEnv checks the environment has results stored for the project
+func (m *MySQL) validateEnv(ctx context.Context, project, env string) error {
+	var validEnvs []string
+	err := m.db.SelectContext(ctx, &validEnvs, "SELECT DISTINCT EnvName FROM db_environment_tests WHERE Project = ?", project)
+	if err != nil {
+		return fmt.Errorf("failed to execute SQL query for list of valid environments: %v", err)
+	}
+	for _, e := range validEnvs {
+		if env == e {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid environment. Not found in database: %q", env)
+}
+
+// GetTestCharts writes the individual test chart data to a map with the keys flakeByDay and flakeByWeek
+func (m *MySQL) GetTestCharts(ctx context.Context, f Filter, env, test string) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+
+	if err := m.validateEnv(ctx, f.Project, env); err != nil {
+		return nil, err
+	}
+
+	// Groups the days together by period, calculating flake percentage and average duration from the daily runs
+	// and aggregating the individual results and durations of the runs still stored into a json array
+	testChartQuery := func(period string) string {
+		return `
+	WITH` + mysqlTestDaysData(f) + `,` + mysqlLastnData(f) + `, points AS (
+		SELECT ` + mysqlTrunc(period, "Day") + ` AS StartOfDate,
+		SUM(TotalDuration) / SUM(Runs) AS AvgDuration,
+		ROUND(SUM(Fails) * 100.0 / SUM(Runs), 2) AS FlakePercentage
+		FROM test_days
+		WHERE TestName = :test
+		GROUP BY StartOfDate
+	), commits AS (
+		SELECT ` + mysqlTrunc(period, "TestTime") + ` AS StartOfDate,
+		` + mysqlJSONArray("'commit', CommitID, 'result', Result, 'duration', Duration") + ` AS Commits
+		FROM lastn_data
+		WHERE TestName = :test
+		GROUP BY StartOfDate
+	)
+	SELECT p.StartOfDate, p.AvgDuration, p.FlakePercentage, COALESCE(c.Commits, '[]') AS Commits
+	FROM points p
+	LEFT JOIN commits c ON c.StartOfDate = p.StartOfDate
+	ORDER BY p.StartOfDate DESC
+	`
+	}
+	args := f.mysqlArgs(map[string]interface{}{"test": test, "env": env})
+
+	var flakeByDay []models.DBTestRateAndDuration
+	err := m.selectNamed(ctx, &flakeByDay, testChartQuery("day"), args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for flake rate and duration by day chart: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake rate and duration by day chart since start of handler", time.Since(start).Seconds())
+
+	var flakeByWeek []models.DBTestRateAndDuration
+	err = m.selectNamed(ctx, &flakeByWeek, testChartQuery("week"), args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for flake rate and duration by week chart: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake rate and duration by week chart since start of handler", time.Since(start).Seconds())
+
+	var flakeByMonth []models.DBTestRateAndDuration
+	err = m.selectNamed(ctx, &flakeByMonth, testChartQuery("month"), args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for flake rate and duration by month chart: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake rate and duration by month chart since start of handler", time.Since(start).Seconds())
+
+	data := map[string]interface{}{
+		"flakeByDay":   flakeByDay,
+		"flakeByWeek":  flakeByWeek,
+		"flakeByMonth": flakeByMonth,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather individual test chart data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// GetTestHistory writes the results of the test on the last n commits to a map with the keys history and firstFailure
+func (m *MySQL) GetTestHistory(ctx context.Context, f Filter, env, test string, n int) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+
+	if err := m.validateEnv(ctx, f.Project, env); err != nil {
+		return nil, err
+	}
+
+	// Orders the runs by the position of their commit in the history, runs without one are ordered by time before the ones with one
+	sqlQuery := `
+	SELECT t.CommitID, e.ParentCommit, e.CommitOrder, t.TestTime, t.Result, t.Duration
+	FROM (
+		SELECT * FROM db_test_cases
+		WHERE Result != 'skip' AND TestName = :test AND EnvName = :env AND ` + f.mysqlWhere() + `
+	) t
+	JOIN db_environment_tests e ON e.Project = t.Project AND e.CommitID = t.CommitID AND e.EnvName = t.EnvName
+	ORDER BY e.CommitOrder DESC, t.TestTime DESC
+	LIMIT :n
+	`
+	var history []models.DBTestHistory
+	err := m.selectNamed(ctx, &history, sqlQuery, f.mysqlArgs(map[string]interface{}{"test": test, "env": env, "n": n}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test history: %v", err)
+	}
+	reverseHistory(history)
+
+	data := map[string]interface{}{
+		"history":      history,
+		"firstFailure": firstFailure(history),
+	}
+	log.Printf("\nduration metric: took %f seconds to gather test history since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// GetEnvCharts writes the overall environment charts to a map with the keys recentFlakePercentTable, flakeRateByWeek, flakeRateByDay, and countsAndDurations
+func (m *MySQL) GetEnvCharts(ctx context.Context, f Filter, env string, testsInTop int) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+
+	if err := m.validateEnv(ctx, f.Project, env); err != nil {
+		return nil, err
+	}
+
+	// Number of days to use to look for "flaky-est" tests.
+	dateRange := f.window()
+
+	// This query first makes a temp table containing the :dates (30) most recent dates with runs
+	// Then it computes the recentCutoff and prevCutoff (15th most recent and 30th most recent dates), all of the data is recent if there are fewer dates
+	// Then we calculate the flake rate, the flake rate growth and the number of runs and fails from the daily runs
+	// for the 15 most recent days and the 15 days following that, leaving out the tests with fewer than :minRuns recent runs
+	sqlQuer := `
+	WITH` + mysqlTestDaysData(f) + `, dates AS (
+		SELECT DISTINCT Day AS Date
+		FROM test_days
+		ORDER BY Date DESC
+		LIMIT :dates
+	), recentCutoff AS (
+		SELECT Date
+		FROM dates
+		ORDER BY Date DESC
+		LIMIT 1 OFFSET :recentOffset
+	), prevCutoff AS (
+		SELECT Date
+		FROM dates
+		ORDER BY Date DESC
+		LIMIT 1 OFFSET :prevOffset
+	), cutoffs AS (
+		SELECT COALESCE((SELECT Date FROM recentCutoff), DATE('1000-01-01')) AS RecentCutoff,
+		COALESCE((SELECT Date FROM prevCutoff), DATE('1000-01-01')) AS PrevCutoff
+	), temp AS (
+	SELECT TestName,
+	ROUND(COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN Fails END) * 100.0 / NULLIF(SUM(CASE WHEN Day >= c.RecentCutoff THEN Runs END), 0), 0), 2) AS RecentFlakePercentage,
+	ROUND(COALESCE(SUM(CASE WHEN Day < c.RecentCutoff AND Day >= c.PrevCutoff THEN Fails END) * 100.0 / NULLIF(SUM(CASE WHEN Day < c.RecentCutoff AND Day >= c.PrevCutoff THEN Runs END), 0), 0), 2) AS PrevFlakePercentage,
+	COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN Runs END), 0) AS RecentRuns,
+	COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN Fails END), 0) AS RecentFails
+	FROM test_days, cutoffs c
+	GROUP BY TestName
+	)
+	SELECT TestName, RecentFlakePercentage, RecentFlakePercentage - PrevFlakePercentage AS GrowthRate, RecentRuns, RecentFails
+	FROM temp
+	WHERE RecentRuns >= :minRuns
+	ORDER BY RecentFlakePercentage DESC, RecentRuns DESC
+	`
+	var flakeRates []models.DBFlakeRow
+	err := m.selectNamed(ctx, &flakeRates, sqlQuer, f.mysqlArgs(map[string]interface{}{
+		"dates": 2 * dateRange, "recentOffset": dateRange - 1, "prevOffset": 2*dateRange - 1, "minRuns": f.minRuns(), "env": env,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for flake table: %v", err)
+	}
+	for i := range flakeRates {
+		flakeRates[i].ConfidenceLow, flakeRates[i].ConfidenceHigh = wilsonInterval(flakeRates[i].RecentFails, flakeRates[i].RecentRuns)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake table since start of handler", time.Since(start).Seconds())
+
+	// Gets the results in commit order of the tests of the table that failed, to tell flaky tests from broken ones.
+	// The tests that did not fail are stable, so their results are not read
+	var results []testResult
+	if len(flakeRates) > 0 {
+		sqlQuer = `
+	WITH` + mysqlLastnData(f) + `
+	SELECT t.TestName, t.CommitID, t.Result
+	FROM lastn_data t
+	JOIN db_environment_tests e ON e.Project = t.Project AND e.CommitID = t.CommitID AND e.EnvName = t.EnvName
+	WHERE t.TestName IN (:tests) AND t.TestName IN (SELECT TestName FROM lastn_data WHERE Result = 'fail')
+	ORDER BY t.TestName, e.CommitOrder, t.TestTime
+	`
+		err = m.selectNamed(ctx, &results, sqlQuer, f.mysqlArgs(map[string]interface{}{"env": env, "tests": flakeTableTests(flakeRates)}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute SQL query for flakiness scores: %v", err)
+		}
+	}
+	setFlakiness(flakeRates, results)
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for flakiness scores since start of handler", time.Since(start).Seconds())
+
+	var topTestNames []string
+	for _, row := range flakeRates {
+		topTestNames = append(topTestNames, row.TestName)
+		if len(topTestNames) >= testsInTop {
+			break
+		}
+	}
+
+	// Gets the data on just the top ten previously calculated and aggregates flake rates and results per date
+	var flakeRateByDay []models.DBFlakeBy
+	if len(topTestNames) > 0 {
+		sqlQuer = `
+	WITH` + mysqlTestDaysData(f) + `,` + mysqlLastnData(f) + `, commits AS (
+		SELECT TestName, DATE(TestTime) AS StartOfDate,
+		` + mysqlJSONArray("'commit', CommitID, 'result', Result, 'duration', Duration") + ` AS Commits
+		FROM lastn_data
+		WHERE TestName IN (:top)
+		GROUP BY TestName, StartOfDate
+	)
+	SELECT d.TestName,
+	d.Day AS StartOfDate,
+	d.Fails * 100.0 / d.Runs AS FlakePercentage,
+	COALESCE(c.Commits, '[]') AS Commits
+	FROM test_days d
+	LEFT JOIN commits c ON c.TestName = d.TestName AND c.StartOfDate = d.Day
+	WHERE d.TestName IN (:top)
+	ORDER BY StartOfDate DESC
+	`
+		err = m.selectNamed(ctx, &flakeRateByDay, sqlQuer, f.mysqlArgs(map[string]interface{}{"env": env, "top": topTestNames}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute SQL query for by day flake chart: %v", err)
+		}
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for day flake chart since start of handler", time.Since(start).Seconds())
+
+	// Filters to get the top flakiest in the past week, calculating flake rate per week for those tests
+	sqlQuer = `
+	WITH` + mysqlTestDaysData(f) + `,` + mysqlLastnData(f) + `, recent_week AS (
+		SELECT MAX(` + mysqlTrunc("week", "Day") + `) AS weekCutoff
+		FROM test_days
+	),
+	top_flakiest AS (
+		SELECT TestName, SUM(Fails) * 100.0 / SUM(Runs) AS RecentFlakePercentage
+		FROM test_days
+		WHERE Day >= (SELECT weekCutoff FROM recent_week)
+		GROUP BY TestName
+		HAVING SUM(Runs) >= :minRuns
+		ORDER BY RecentFlakePercentage DESC
+		LIMIT :top
+	),
+	points AS (
+		SELECT d.TestName, ` + mysqlTrunc("week", "d.Day") + ` AS StartOfDate,
+		ROUND(SUM(d.Fails) * 100.0 / SUM(d.Runs), 2) AS FlakePercentage
+		FROM test_days d
+		JOIN top_flakiest t ON t.TestName = d.TestName
+		GROUP BY d.TestName, StartOfDate
+	),
+	commits AS (
+		SELECT l.TestName, ` + mysqlTrunc("week", "l.TestTime") + ` AS StartOfDate,
+		` + mysqlJSONArray("'commit', l.CommitID, 'result', l.Result, 'duration', l.Duration") + ` AS Commits
+		FROM lastn_data l
+		JOIN top_flakiest t ON t.TestName = l.TestName
+		GROUP BY l.TestName, StartOfDate
+	)
+	SELECT p.TestName, p.StartOfDate, p.FlakePercentage, COALESCE(c.Commits, '[]') AS Commits
+	FROM points p
+	LEFT JOIN commits c ON c.TestName = p.TestName AND c.StartOfDate = p.StartOfDate
+	ORDER BY p.StartOfDate DESC
+	`
+	var flakeRateByWeek []models.DBFlakeBy
+	err = m.selectNamed(ctx, &flakeRateByWeek, sqlQuer, f.mysqlArgs(map[string]interface{}{"top": testsInTop, "minRuns": f.minRuns(), "env": env}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for by week flake chart: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake by week chart since start of handler", time.Since(start).Seconds())
+
+	// Calculates for each date the average duration and number of tests from the daily runs,
+	// aggregating the number of tests and duration of the runs still stored into a json array
+	sqlQuer = `
+	WITH` + mysqlEnvDaysData(f) + `,` + mysqlLastnEnvData(f) + `, commits AS (
+		SELECT DATE(TestTime) AS StartOfDate,
+		` + mysqlJSONArray("'commit', CommitID, 'testCount', NumberOfPass + NumberOfFail, 'duration', TotalDuration") + ` AS Commits
+		FROM lastn_env_data
+		GROUP BY StartOfDate
+	)
+	SELECT
+	d.Day AS StartOfDate,
+	(d.NumberOfPass + d.NumberOfFail) * 1.0 / d.Runs AS TestCount,
+	d.TotalDuration / d.Runs AS Duration,
+	COALESCE(c.Commits, '[]') AS Commits
+	FROM env_days d
+	LEFT JOIN commits c ON c.StartOfDate = d.Day
+	ORDER BY StartOfDate DESC
+	`
+	var countsAndDurations []models.DBEnvDuration
+	err = m.selectNamed(ctx, &countsAndDurations, sqlQuer, f.mysqlArgs(map[string]interface{}{"env": env}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environment test count and duration chart: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for env duration chart since start of handler", time.Since(start).Seconds())
+
+	data := map[string]interface{}{
+		"recentFlakePercentTable": flakeRates,
+		"flakeRateByWeek":         flakeRateByWeek,
+		"flakeRateByDay":          flakeRateByDay,
+		"countsAndDurations":      countsAndDurations,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather env chart data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// GetDurationRegressions writes the tests that got slower on the environment, or on every environment if env is empty, to a map with the key durationRegressions
+func (m *MySQL) GetDurationRegressions(ctx context.Context, f Filter, env string, ratio float64) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+
+	regressions, err := m.durationRegressions(ctx, f, env, ratio)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{
+		"durationRegressions": regressions,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather duration regressions since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// durationRegressions finds the tests whose median duration in the last window days is ratio times their baseline before,
+// and the commit the duration shifted at
+func (m *MySQL) durationRegressions(ctx context.Context, f Filter, env string, ratio float64) ([]models.DBDurationRegression, error) {
+	// Compares the median duration of the passing runs of each test in the recent window to the median of its daily average duration before it,
+	// the days with failed runs are left out of the baseline as those runs may have stopped early or timed out.
+	// MySQL has no PERCENTILE_CONT, the median is the average of the middle one or two durations of each window
+	sqlQuery := `
+	WITH ` + mysqlBaselineDaysData(f) + `, recent AS (
+		SELECT EnvName, TestName, Duration FROM db_test_cases
+		WHERE Result = 'pass' AND (:env = '' OR EnvName = :env) AND TestTime >= :recentStart AND ` + f.mysqlWhere() + `
+	), data AS (
+		SELECT EnvName, TestName, Duration, Runs, FALSE AS Recent FROM baseline_days
+		UNION ALL
+		SELECT EnvName, TestName, Duration, 1 AS Runs, TRUE AS Recent FROM recent
+	), ranked AS (
+		SELECT EnvName, TestName, Duration, Runs, Recent,
+		ROW_NUMBER() OVER (PARTITION BY EnvName, TestName, Recent ORDER BY Duration) AS RowNumber,
+		COUNT(*) OVER (PARTITION BY EnvName, TestName, Recent) AS Points
+		FROM data
+	), medians AS (
+		SELECT EnvName, TestName,
+		AVG(CASE WHEN NOT Recent AND RowNumber IN (FLOOR((Points + 1) / 2), CEIL((Points + 1) / 2)) THEN Duration END) AS BaselineMedian,
+		AVG(CASE WHEN Recent AND RowNumber IN (FLOOR((Points + 1) / 2), CEIL((Points + 1) / 2)) THEN Duration END) AS RecentMedian,
+		COALESCE(SUM(CASE WHEN NOT Recent THEN Runs END), 0) AS BaselineRuns,
+		COALESCE(SUM(CASE WHEN Recent THEN Runs END), 0) AS RecentRuns
+		FROM ranked
+		GROUP BY EnvName, TestName
+	)
+	SELECT EnvName, TestName, BaselineMedian, RecentMedian, BaselineRuns, RecentRuns
+	FROM medians
+	WHERE BaselineRuns >= :minRuns AND RecentRuns >= :minRuns AND RecentMedian >= BaselineMedian * :ratio AND RecentMedian - BaselineMedian >= :minSeconds
+	ORDER BY BaselineMedian = 0 DESC, RecentMedian / NULLIF(BaselineMedian, 0) DESC
+	`
+	var regressions []models.DBDurationRegression
+	err := m.selectNamed(ctx, &regressions, sqlQuery, f.mysqlArgs(map[string]interface{}{
+		"recentStart": regressionStart(f), "minRuns": f.minRuns(), "env": env, "ratio": ratio, "minSeconds": minRegressionSeconds,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for duration regressions: %v", err)
+	}
+	if len(regressions) == 0 {
+		return regressions, nil
+	}
+	setRatios(regressions)
+
+	// Gets the durations of the regressed tests in commit order to find the commit they shifted at.
+	// The environments and tests are matched separately, the durations of the other pairs are ignored by setShiftCommits
+	sqlQuery = `
+	SELECT t.EnvName, t.TestName, t.CommitID, t.Duration
+	FROM (
+		SELECT * FROM db_test_cases
+		WHERE Result = 'pass' AND EnvName IN (:envs) AND TestName IN (:tests) AND ` + f.mysqlWhere() + `
+	) t
+	JOIN db_environment_tests e ON e.Project = t.Project AND e.CommitID = t.CommitID AND e.EnvName = t.EnvName
+	ORDER BY t.EnvName, t.TestName, e.CommitOrder, t.TestTime
+	`
+	envs := make([]string, len(regressions))
+	tests := make([]string, len(regressions))
+	for i, r := range regressions {
+		envs[i], tests[i] = r.EnvName, r.TestName
+	}
+	var durations []testDuration
+	if err := m.selectNamed(ctx, &durations, sqlQuery, f.mysqlArgs(map[string]interface{}{"envs": envs, "tests": tests})); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for durations of the regressed tests: %v", err)
+	}
+	setShiftCommits(regressions, durations)
+	return regressions, nil
+}
+
+// GetRunStats returns the stats of the post-merge runs of the environment and branch of run between since and run
+func (m *MySQL) GetRunStats(ctx context.Context, run models.DBEnvironmentTest, since time.Time) (*models.DBRunStats, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	stats := &models.DBRunStats{
+		PrevResults: map[string]string{},
+		TestRuns:    map[string]int{},
+		TestFails:   map[string]int{},
+	}
+
+	var prevCommits []string
+	err := m.db.SelectContext(ctx, &prevCommits, `
+	SELECT CommitID FROM db_environment_tests
+	WHERE Project = ? AND EnvName = ? AND Branch = ? AND PR = '' AND CommitID != ? AND TestTime < ?
+	ORDER BY TestTime DESC
+	LIMIT 1
+	`, run.Project, run.EnvName, run.Branch, run.CommitID, run.TestTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for previous run: %v", err)
+	}
+	if len(prevCommits) > 0 {
+		stats.PrevCommit = prevCommits[0]
+		var prevResults []struct {
+			TestName string
+			Result   string
+		}
+		err = m.db.SelectContext(ctx, &prevResults, `SELECT TestName, Result FROM db_test_cases WHERE Project = ? AND CommitID = ? AND EnvName = ?`, run.Project, stats.PrevCommit, run.EnvName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute SQL query for previous run results: %v", err)
+		}
+		for _, r := range prevResults {
+			stats.PrevResults[r.TestName] = r.Result
+		}
+	}
+
+	var testCounts []struct {
+		TestName string
+		Runs     int
+		Fails    int
+	}
+	err = m.db.SelectContext(ctx, &testCounts, `
+	SELECT TestName, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails
+	FROM db_test_cases
+	WHERE Project = ? AND EnvName = ? AND Branch = ? AND PR = '' AND Result != 'skip' AND TestTime >= ? AND TestTime < ?
+	GROUP BY TestName
+	`, run.Project, run.EnvName, run.Branch, since.UTC(), run.TestTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test failure counts: %v", err)
+	}
+	for _, c := range testCounts {
+		stats.TestRuns[c.TestName] = c.Runs
+		stats.TestFails[c.TestName] = c.Fails
+	}
+
+	err = m.db.GetContext(ctx, stats, `
+	SELECT COUNT(*) AS EnvRuns, COALESCE(AVG(NumberOfFail), 0) AS AvgFails
+	FROM db_environment_tests
+	WHERE Project = ? AND EnvName = ? AND Branch = ? AND PR = '' AND TestTime >= ? AND TestTime < ?
+	`, run.Project, run.EnvName, run.Branch, since.UTC(), run.TestTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environment failure average: %v", err)
+	}
+	return stats, nil
+}
+
+// GetTestFailures returns the number of runs and failures of every test on every environment
+func (m *MySQL) GetTestFailures(ctx context.Context, f Filter) ([]models.DBTestFailures, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	sqlQuery := `
+	SELECT TestName, EnvName, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails,
+	CONCAT('[', GROUP_CONCAT(CASE WHEN Result = 'fail' THEN JSON_QUOTE(CommitID) END ORDER BY TestTime DESC SEPARATOR ','), ']') AS FailedCommits
+	FROM db_test_cases
+	WHERE Result != 'skip' AND ` + f.mysqlWhere() + `
+	GROUP BY TestName, EnvName
+	ORDER BY TestName, EnvName
+	`
+	var failures []models.DBTestFailures
+	if err := m.selectNamed(ctx, &failures, sqlQuery, f.mysqlArgs(nil)); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test failures: %v", err)
+	}
+	return failures, nil
+}
+
+// GetOwners writes the flake rates and failures of the tests per owner to a map with the keys owners and ownerTests
+func (m *MySQL) GetOwners(ctx context.Context, f Filter) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+
+	// Calculates the flake rate of all of the tests of each owner, tests without owners are grouped under ''
+	sqlQuery := `
+	SELECT Owner, COUNT(DISTINCT TestName) AS Tests, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails,
+	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage
+	FROM db_test_cases
+	WHERE Result != 'skip' AND ` + f.mysqlWhere() + `
+	GROUP BY Owner
+	ORDER BY FlakePercentage DESC
+	`
+	var owners []models.DBOwnerRow
+	if err := m.selectNamed(ctx, &owners, sqlQuery, f.mysqlArgs(nil)); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for owners table: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for owners table since start of handler", time.Since(start).Seconds())
+
+	// Calculates the flake rate of each failing test of each owner on each environment
+	sqlQuery = `
+	SELECT Owner, TestName, EnvName, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails,
+	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage
+	FROM db_test_cases
+	WHERE Result != 'skip' AND ` + f.mysqlWhere() + `
+	GROUP BY Owner, TestName, EnvName
+	HAVING COUNT(CASE WHEN Result = 'fail' THEN 1 END) > 0
+	ORDER BY Owner, FlakePercentage DESC
+	`
+	var ownerTests []models.DBOwnerTest
+	if err := m.selectNamed(ctx, &ownerTests, sqlQuery, f.mysqlArgs(nil)); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for owner tests table: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"owners":     owners,
+		"ownerTests": ownerTests,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather owner data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// Prune deletes the rows of the runs before the given time, they are kept in the daily rollups
+func (m *MySQL) Prune(ctx context.Context, before time.Time) (*models.DBPruneResult, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SQL transaction: %v", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // rollback after commit is a no-op
+
+	before = before.UTC()
+	var pruned models.DBPruneResult
+	res, err := tx.ExecContext(ctx, `DELETE FROM db_test_cases WHERE TestTime < ?`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete test cases: %v", err)
+	}
+	if pruned.TestCases, err = res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to count deleted test cases: %v", err)
+	}
+	res, err = tx.ExecContext(ctx, `DELETE FROM db_environment_tests WHERE TestTime < ?`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete environment tests: %v", err)
+	}
+	if pruned.EnvironmentTests, err = res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to count deleted environment tests: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit prune transaction: %v", err)
+	}
+	return &pruned, nil
+}
+
+// GetDailyTrend writes the daily runs, flake rate and duration of a test on an environment, or of the environment if test is empty,
+// to a map with the key dailyTrend. The fails and flake rate of an environment are the ones of all of its tests.
+// The daily rollups keep the pruned runs, so the trend covers the whole history unless the filter has a From.
+func (m *MySQL) GetDailyTrend(ctx context.Context, f Filter, env, test string) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+	if f.From.IsZero() {
+		f.From = time.Unix(0, 0)
+	}
+
+	var sqlQuery string
+	if test == "" {
+		sqlQuery = `
+		WITH` + mysqlEnvDaysData(f) + `
+		SELECT Day, Runs, NumberOfFail AS Fails,
+		ROUND(COALESCE(NumberOfFail * 100.0 / NULLIF(NumberOfFail + NumberOfPass, 0), 0), 2) AS FlakePercentage,
+		TotalDuration / Runs AS AvgDuration
+		FROM env_days
+		ORDER BY Day
+		`
+	} else {
+		sqlQuery = `
+		WITH` + mysqlTestDaysData(f) + `
+		SELECT Day, Runs, Fails,
+		ROUND(Fails * 100.0 / Runs, 2) AS FlakePercentage,
+		TotalDuration / Runs AS AvgDuration
+		FROM test_days
+		WHERE TestName = :test
+		ORDER BY Day
+		`
+	}
+	var trend []models.DBDailyTrend
+	if err := m.selectNamed(ctx, &trend, sqlQuery, f.mysqlArgs(map[string]interface{}{"test": test, "env": env})); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for daily trend: %v", err)
+	}
+	data := map[string]interface{}{
+		"dailyTrend": trend,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather daily trend data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// GetOverview writes the overview charts to a map with the keys summaryAvgFail, summaryTable and durationRegressions
+func (m *MySQL) GetOverview(ctx context.Context, f Filter) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+	// Calculates the average number of failures and average duration per day per environment from the daily runs
+	sqlQuery := `
+	WITH` + mysqlEnvDaysData(f) + `
+	SELECT Day AS StartOfDate, EnvName, NumberOfFail * 1.0 / Runs AS AvgFailedTests, TotalDuration / Runs AS AvgDuration
+	FROM env_days
+	ORDER BY StartOfDate, EnvName
+	`
+	var summaryAvgFail []models.DBSummaryAvgFail
+	err := m.selectNamed(ctx, &summaryAvgFail, sqlQuery, f.mysqlArgs(map[string]interface{}{"env": ""}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for summary chart: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for summary duration and failure charts since start of handler", time.Since(start).Seconds())
+
+	// Number of days to use to look for "flaky-est" envs.
+	dateRange := f.window()
+
+	// Computes the average number of fails and the number of runs for each environment for each time frame from the daily runs, all of the data is recent if there are fewer dates
+	// Then calculates the change in the average number of fails between the time frames, leaving out the environments with fewer than :minRuns recent runs
+	sqlQuery = `
+	WITH` + mysqlEnvDaysData(f) + `, dates AS (
+		SELECT DISTINCT Day AS Date
+		FROM env_days
+		ORDER BY Date DESC
+		LIMIT :dates
+	), recentCutoff AS (
+		SELECT Date
+		FROM dates
+		ORDER BY Date DESC
+		LIMIT 1 OFFSET :recentOffset
+	), prevCutoff AS (
+		SELECT Date
+		FROM dates
+		ORDER BY Date DESC
+		LIMIT 1 OFFSET :prevOffset
+	), cutoffs AS (
+		SELECT COALESCE((SELECT Date FROM recentCutoff), DATE('1000-01-01')) AS RecentCutoff,
+		COALESCE((SELECT Date FROM prevCutoff), DATE('1000-01-01')) AS PrevCutoff
+	), temp AS (
+	SELECT EnvName,
+	ROUND(COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN NumberOfFail END) * 1.0 / NULLIF(SUM(CASE WHEN Day >= c.RecentCutoff THEN Runs END), 0), 0), 2) AS RecentNumberOfFail,
+	ROUND(COALESCE(SUM(CASE WHEN Day < c.RecentCutoff AND Day >= c.PrevCutoff THEN NumberOfFail END) * 1.0 / NULLIF(SUM(CASE WHEN Day < c.RecentCutoff AND Day >= c.PrevCutoff THEN Runs END), 0), 0), 2) AS PrevNumberOfFail,
+	COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN Runs END), 0) AS RecentRuns
+	FROM env_days, cutoffs c
+	GROUP BY EnvName
+	)
+	SELECT EnvName, RecentNumberOfFail, RecentNumberOfFail - PrevNumberOfFail AS Growth, RecentRuns
+	FROM temp
+	WHERE RecentRuns >= :minRuns
+	ORDER BY RecentNumberOfFail DESC
+	`
+	var summaryTable []models.DBSummaryTable
+	err = m.selectNamed(ctx, &summaryTable, sqlQuery, f.mysqlArgs(map[string]interface{}{
+		"dates": 2 * dateRange, "recentOffset": dateRange - 1, "prevOffset": 2*dateRange - 1, "minRuns": f.minRuns(), "env": "",
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for flake table: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for summary failure change table since start of handler", time.Since(start).Seconds())
+
+	regressions, err := m.durationRegressions(ctx, f, "", DefaultRegressionRatio)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for duration regressions since start of handler", time.Since(start).Seconds())
+
+	data := map[string]interface{}{
+		"summaryAvgFail":      summaryAvgFail,
+		"summaryTable":        summaryTable,
+		"durationRegressions": regressions,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather summary data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
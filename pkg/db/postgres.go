@@ -1,13 +1,14 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // Blank import used for registering postgres driver as a database driver
+	"github.com/lib/pq"
 	"github.com/medyagh/gopogh/pkg/models"
 )
 
@@ -37,14 +38,131 @@ var pgTestCasesTableSchema = `
 	);
 `
 
+// pgMigrations are the schema changes since the tables were first created
+var pgMigrations = []migration{
+	// project dimension, rows stored before it belong to the '' project
+	{
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS Project TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests DROP CONSTRAINT IF EXISTS db_environment_tests_pkey`,
+		`ALTER TABLE db_environment_tests ADD PRIMARY KEY (Project, CommitID, EnvName)`,
+		`ALTER TABLE db_test_cases ADD COLUMN IF NOT EXISTS Project TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_test_cases DROP CONSTRAINT IF EXISTS db_test_cases_pkey`,
+		`ALTER TABLE db_test_cases ADD PRIMARY KEY (Project, CommitID, EnvName, TestName)`,
+	},
+	// branch dimension, and the PR of the runs so the environment charts can leave out PR runs
+	{
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS Branch TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS PR TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_test_cases ADD COLUMN IF NOT EXISTS Branch TEXT NOT NULL DEFAULT ''`,
+		`UPDATE db_environment_tests e SET PR = t.PR FROM (SELECT DISTINCT Project, CommitID, EnvName, PR FROM db_test_cases WHERE PR IS NOT NULL) t
+			WHERE e.Project = t.Project AND e.CommitID = t.CommitID AND e.EnvName = t.EnvName`,
+	},
+	// run metadata
+	{
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS GoVersion TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS GOOS TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS GOARCH TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS Hostname TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS Trigger TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS Labels JSONB NOT NULL DEFAULT '{}'`,
+		`CREATE INDEX IF NOT EXISTS db_environment_tests_labels ON db_environment_tests USING GIN (Labels)`,
+	},
+	// free form details, CommitID used to hold the -details of the run so they are copied over
+	{
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS Details TEXT NOT NULL DEFAULT ''`,
+		`UPDATE db_environment_tests SET Details = CommitID`,
+	},
+	// commit history, for ordering the runs by commit instead of by time
+	{
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS ParentCommit TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN IF NOT EXISTS CommitOrder BIGINT NOT NULL DEFAULT 0`,
+	},
+	// owners of the tests
+	{
+		`ALTER TABLE db_test_cases ADD COLUMN IF NOT EXISTS Owner TEXT NOT NULL DEFAULT ''`,
+	},
+	// daily rollups of the runs and test cases, kept up to date when a run is stored, see rollupRun
+	{
+		`CREATE TABLE IF NOT EXISTS db_test_cases_daily (
+			Project TEXT NOT NULL,
+			EnvName TEXT NOT NULL,
+			Branch TEXT NOT NULL,
+			PostMerge BOOLEAN NOT NULL,
+			TestName TEXT NOT NULL,
+			Day DATE NOT NULL,
+			Runs INTEGER NOT NULL,
+			Fails INTEGER NOT NULL,
+			Skips INTEGER NOT NULL,
+			TotalDuration FLOAT NOT NULL,
+			PRIMARY KEY (Project, EnvName, Branch, PostMerge, TestName, Day)
+		)`,
+		`CREATE TABLE IF NOT EXISTS db_environment_tests_daily (
+			Project TEXT NOT NULL,
+			EnvName TEXT NOT NULL,
+			Branch TEXT NOT NULL,
+			PostMerge BOOLEAN NOT NULL,
+			Day DATE NOT NULL,
+			Runs INTEGER NOT NULL,
+			NumberOfFail INTEGER NOT NULL,
+			NumberOfPass INTEGER NOT NULL,
+			NumberOfSkip INTEGER NOT NULL,
+			TotalDuration FLOAT NOT NULL,
+			PRIMARY KEY (Project, EnvName, Branch, PostMerge, Day)
+		)`,
+	},
+	// the daily rollups are kept up to date at ingest so the charts can read them, the rows not pruned yet are added to them
+	{
+		fmt.Sprintf(pgRollupTestCasesSQL, 1, "TRUE"),
+		fmt.Sprintf(pgRollupEnvironmentTestsSQL, 1, "TRUE"),
+	},
+}
+
+// pgRollupTestCasesSQL adds the test cases matching the condition (%[2]s) to the daily rollups, multiplied by %[1]d
+// so a run can be taken out of them before it is replaced
+const pgRollupTestCasesSQL = `
+	INSERT INTO db_test_cases_daily (Project, EnvName, Branch, PostMerge, TestName, Day, Runs, Fails, Skips, TotalDuration)
+	SELECT Project, EnvName, Branch, COALESCE(PR, '') = '', TestName, DATE_TRUNC('day', TestTime)::date,
+	%[1]d * COUNT(CASE WHEN Result != 'skip' THEN 1 END), %[1]d * COUNT(CASE WHEN Result = 'fail' THEN 1 END), %[1]d * COUNT(CASE WHEN Result = 'skip' THEN 1 END),
+	%[1]d * COALESCE(SUM(CASE WHEN Result != 'skip' THEN Duration END), 0)
+	FROM db_test_cases
+	WHERE TestTime IS NOT NULL AND (%[2]s)
+	GROUP BY 1, 2, 3, 4, 5, 6
+	ON CONFLICT (Project, EnvName, Branch, PostMerge, TestName, Day)
+	DO UPDATE SET (Runs, Fails, Skips, TotalDuration) = (db_test_cases_daily.Runs + EXCLUDED.Runs, db_test_cases_daily.Fails + EXCLUDED.Fails,
+		db_test_cases_daily.Skips + EXCLUDED.Skips, db_test_cases_daily.TotalDuration + EXCLUDED.TotalDuration)
+`
+
+// pgRollupEnvironmentTestsSQL adds the runs matching the condition (%[2]s) to the daily rollups, multiplied by %[1]d
+const pgRollupEnvironmentTestsSQL = `
+	INSERT INTO db_environment_tests_daily (Project, EnvName, Branch, PostMerge, Day, Runs, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration)
+	SELECT Project, EnvName, Branch, PR = '', DATE_TRUNC('day', TestTime)::date,
+	%[1]d * COUNT(*), %[1]d * SUM(NumberOfFail), %[1]d * SUM(NumberOfPass), %[1]d * SUM(NumberOfSkip), %[1]d * SUM(TotalDuration)
+	FROM db_environment_tests
+	WHERE TestTime IS NOT NULL AND (%[2]s)
+	GROUP BY 1, 2, 3, 4, 5
+	ON CONFLICT (Project, EnvName, Branch, PostMerge, Day)
+	DO UPDATE SET (Runs, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration) = (db_environment_tests_daily.Runs + EXCLUDED.Runs,
+		db_environment_tests_daily.NumberOfFail + EXCLUDED.NumberOfFail, db_environment_tests_daily.NumberOfPass + EXCLUDED.NumberOfPass,
+		db_environment_tests_daily.NumberOfSkip + EXCLUDED.NumberOfSkip, db_environment_tests_daily.TotalDuration + EXCLUDED.TotalDuration)
+`
+
+// pgRunCondition is the condition of the rows of a run, taking the project, commit and environment as parameters
+const pgRunCondition = "Project = $1 AND CommitID = $2 AND EnvName = $3"
+
+// pgMigrationLock serializes schema migrations of concurrent gopogh runs
+const pgMigrationLock = `SELECT pg_advisory_xact_lock(1001)`
+
 type Postgres struct {
-	db   *sqlx.DB
-	path string
+	db      *sqlx.DB
+	path    string
+	timeout time.Duration
 }
 
 // Set adds/updates rows to the database
-func (m *Postgres) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
-	tx, err := m.db.DB.Begin()
+func (m *Postgres) Set(ctx context.Context, commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	tx, err := m.db.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create SQL transaction: %v", err)
 	}
@@ -56,35 +174,47 @@ func (m *Postgres) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTes
 		}
 	}()
 
-	sqlInsert := `
-		INSERT INTO db_test_cases (PR, CommitId, EnvName, TestName, Result, TestTime, Duration)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		ON CONFLICT (CommitId, EnvName, TestName)
-		DO UPDATE SET (PR, Result, TestTime, Duration) = (EXCLUDED.PR, EXCLUDED.Result, EXCLUDED.TestTime, EXCLUDED.Duration)
-	`
-	stmt, err := tx.Prepare(sqlInsert)
-	if err != nil {
-		return fmt.Errorf("failed to prepare SQL insert statement: %v", err)
+	// takes the run out of the daily rollups in case it is being replaced, it is added back once stored
+	if err := rollupRun(ctx, tx, -1, commitRow); err != nil {
+		return err
 	}
-	defer stmt.Close()
 
-	for _, r := range dbRows {
-		_, err := stmt.Exec(r.PR, r.CommitID, r.EnvName, r.TestName, r.Result, r.TestTime, r.Duration)
-		if err != nil {
-			return fmt.Errorf("failed to execute SQL insert: %v", err)
-		}
+	dbRows = uniqueTestCases(dbRows)
+	insertTestCases := func(rows int) string {
+		return `
+		INSERT INTO db_test_cases (PR, CommitId, EnvName, TestName, Result, TestTime, Duration, Project, Branch, Owner)
+		VALUES ` + valuesList(rows, 10, true) + `
+		ON CONFLICT (Project, CommitId, EnvName, TestName)
+		DO UPDATE SET (PR, Result, TestTime, Duration, Branch, Owner) = (EXCLUDED.PR, EXCLUDED.Result, EXCLUDED.TestTime, EXCLUDED.Duration, EXCLUDED.Branch, EXCLUDED.Owner)
+	`
+	}
+	err = insertBatches(ctx, tx, len(dbRows), pgInsertBatchSize, insertTestCases, func(i int) []interface{} {
+		r := dbRows[i]
+		return []interface{}{r.PR, r.CommitID, r.EnvName, r.TestName, r.Result, r.TestTime, r.Duration, r.Project, r.Branch, r.Owner}
+	})
+	if err != nil {
+		return err
 	}
 
-	sqlInsert = `
-		INSERT INTO db_environment_tests (CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		ON CONFLICT (CommitId, EnvName)
-		DO UPDATE SET (GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration) = (EXCLUDED.GopoghTime, EXCLUDED.TestTime, EXCLUDED.NumberOfFail, EXCLUDED.NumberOfPass, EXCLUDED.NumberOfSkip, EXCLUDED.TotalDuration)
+	sqlInsert := `
+		INSERT INTO db_environment_tests (CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, Project, Branch, PR, GoVersion, GOOS, GOARCH, Hostname, Trigger, Labels, Details, ParentCommit, CommitOrder)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+		ON CONFLICT (Project, CommitId, EnvName)
+		DO UPDATE SET (GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, Branch, PR, GoVersion, GOOS, GOARCH, Hostname, Trigger, Labels, Details, ParentCommit, CommitOrder) = (EXCLUDED.GopoghTime, EXCLUDED.TestTime, EXCLUDED.NumberOfFail, EXCLUDED.NumberOfPass, EXCLUDED.NumberOfSkip, EXCLUDED.TotalDuration, EXCLUDED.Branch, EXCLUDED.PR, EXCLUDED.GoVersion, EXCLUDED.GOOS, EXCLUDED.GOARCH, EXCLUDED.Hostname, EXCLUDED.Trigger, EXCLUDED.Labels, EXCLUDED.Details, EXCLUDED.ParentCommit, EXCLUDED.CommitOrder)
 		`
-	_, err = tx.Exec(sqlInsert, commitRow.CommitID, commitRow.EnvName, commitRow.GopoghTime, commitRow.TestTime, commitRow.NumberOfFail, commitRow.NumberOfPass, commitRow.NumberOfSkip, commitRow.TotalDuration)
+	_, err = tx.ExecContext(ctx, sqlInsert, commitRow.CommitID, commitRow.EnvName, commitRow.GopoghTime, commitRow.TestTime, commitRow.NumberOfFail, commitRow.NumberOfPass, commitRow.NumberOfSkip, commitRow.TotalDuration, commitRow.Project, commitRow.Branch, commitRow.PR, commitRow.GoVersion, commitRow.GOOS, commitRow.GOARCH, commitRow.Hostname, commitRow.Trigger, commitRow.Labels, commitRow.Details, commitRow.ParentCommit, commitRow.CommitOrder)
 	if err != nil {
 		return fmt.Errorf("failed to execute SQL insert: %v", err)
 	}
+	if err := rollupRun(ctx, tx, 1, commitRow); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM db_test_cases_daily WHERE Project = $1 AND EnvName = $2 AND Runs = 0 AND Skips = 0`, commitRow.Project, commitRow.EnvName); err != nil {
+		return fmt.Errorf("failed to delete empty test case rollups: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM db_environment_tests_daily WHERE Project = $1 AND EnvName = $2 AND Runs = 0`, commitRow.Project, commitRow.EnvName); err != nil {
+		return fmt.Errorf("failed to delete empty environment test rollups: %v", err)
+	}
 
 	err = tx.Commit()
 	if err != nil {
@@ -93,6 +223,17 @@ func (m *Postgres) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTes
 	return rollbackError
 }
 
+// rollupRun adds the stored rows of a run to the daily rollups, multiplied by sign
+func rollupRun(ctx context.Context, tx *sql.Tx, sign int, run models.DBEnvironmentTest) error {
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(pgRollupTestCasesSQL, sign, pgRunCondition), run.Project, run.CommitID, run.EnvName); err != nil {
+		return fmt.Errorf("failed to roll up test cases: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(pgRollupEnvironmentTestsSQL, sign, pgRunCondition), run.Project, run.CommitID, run.EnvName); err != nil {
+		return fmt.Errorf("failed to roll up environment tests: %v", err)
+	}
+	return nil
+}
+
 // newPostgres opens the database returning a Postgres database struct instance
 func newPostgres(cfg config) (*Postgres, error) {
 	path := fmt.Sprintf("host=%s %s", cfg.host, cfg.path)
@@ -100,37 +241,41 @@ func newPostgres(cfg config) (*Postgres, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %v", err)
 	}
+	cfg.configurePool(database)
 	m := &Postgres{
-		db:   database,
-		path: path,
+		db:      database,
+		path:    path,
+		timeout: cfg.timeout,
 	}
 	return m, nil
 }
 
 // Initialize creates the tables within the Postgres database
-func (m *Postgres) Initialize() error {
-	if _, err := m.db.Exec(pgEnvTableSchema); err != nil {
+func (m *Postgres) Initialize(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, pgEnvTableSchema); err != nil {
 		return fmt.Errorf("failed to initialize environment tests table: %v", err)
 	}
-	if _, err := m.db.Exec(pgTestCasesTableSchema); err != nil {
+	if _, err := m.db.ExecContext(ctx, pgTestCasesTableSchema); err != nil {
 		return fmt.Errorf("failed to initialize test cases table: %v", err)
 	}
-	return nil
+	return migrate(ctx, m.db, pgMigrations, pgMigrationLock)
 }
 
 // GetEnvironmentTestsAndTestCases writes the database tables to a map with the keys environmentTests and testCases
-func (m *Postgres) GetEnvironmentTestsAndTestCases() (map[string]interface{}, error) {
+func (m *Postgres) GetEnvironmentTestsAndTestCases(ctx context.Context, project string) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 	start := time.Now()
 
 	var environmentTests []models.DBEnvironmentTest
 	var testCases []models.DBTestCase
 
-	err := m.db.Select(&environmentTests, "SELECT * FROM db_environment_tests ORDER BY TestTime DESC LIMIT 100")
+	err := m.db.SelectContext(ctx, &environmentTests, "SELECT * FROM db_environment_tests WHERE Project = $1 ORDER BY TestTime DESC LIMIT 100", project)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for environment tests: %v", err)
 	}
 
-	err = m.db.Select(&testCases, "SELECT * FROM db_test_cases ORDER BY TestTime DESC LIMIT 100")
+	err = m.db.SelectContext(ctx, &testCases, "SELECT * FROM db_test_cases WHERE Project = $1 ORDER BY TestTime DESC LIMIT 100", project)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for test cases: %v", err)
 
@@ -143,98 +288,220 @@ func (m *Postgres) GetEnvironmentTestsAndTestCases() (map[string]interface{}, er
 	return data, nil
 }
 
-func (m *Postgres) createMaterializedView(env string, viewName string) error {
-	createView := fmt.Sprintf(`
-	CREATE MATERIALIZED VIEW IF NOT EXISTS %s AS 
+// GetRuns returns a page of the stored runs matching the filter and the environment and commit of the query, newest first
+func (m *Postgres) GetRuns(ctx context.Context, f Filter, q RowQuery) (*models.DBRunsPage, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	after, ok, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	sqlQuery := `
+	SELECT * FROM db_environment_tests
+	WHERE ($1::text = '' OR EnvName = $1) AND ($2::text = '' OR CommitID = $2)
+	AND (NOT $3::boolean OR (TestTime, CommitID, EnvName) < ($4::timestamp, $5::text, $6::text))
+	AND ` + f.pgWhere(8) + `
+	ORDER BY TestTime DESC, CommitID DESC, EnvName DESC
+	LIMIT $7
+	`
+	var runs []models.DBEnvironmentTest
+	// one more row than the page tells whether there is a next page
+	err = m.db.SelectContext(ctx, &runs, sqlQuery, f.args(q.Env, q.Commit, ok, after.TestTime.UTC(), after.CommitID, after.EnvName, q.limit()+1)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for runs: %v", err)
+	}
+	page := &models.DBRunsPage{Runs: runs}
+	if len(runs) > q.limit() {
+		page.Runs = runs[:q.limit()]
+		last := page.Runs[len(page.Runs)-1]
+		page.NextCursor = cursor{TestTime: last.TestTime, CommitID: last.CommitID, EnvName: last.EnvName}.encode()
+	}
+	return page, nil
+}
+
+// GetTestCases returns a page of the stored test cases matching the filter and the environment, test, result and commit of the query, newest first
+func (m *Postgres) GetTestCases(ctx context.Context, f Filter, q RowQuery) (*models.DBTestCasesPage, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	after, ok, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	sqlQuery := `
+	SELECT Project, Branch, COALESCE(PR, '') AS PR, CommitID, TestName, Owner, TestTime, Result, Duration, EnvName
+	FROM db_test_cases
+	WHERE ($1::text = '' OR EnvName = $1) AND ($2::text = '' OR TestName = $2) AND ($3::text = '' OR Result = $3) AND ($4::text = '' OR CommitID = $4)
+	AND (NOT $5::boolean OR (TestTime, CommitID, EnvName, TestName) < ($6::timestamp, $7::text, $8::text, $9::text))
+	AND ` + f.pgWhere(11) + `
+	ORDER BY TestTime DESC, CommitID DESC, EnvName DESC, TestName DESC
+	LIMIT $10
+	`
+	var testCases []models.DBTestCase
+	err = m.db.SelectContext(ctx, &testCases, sqlQuery, f.args(q.Env, q.Test, q.Result, q.Commit, ok, after.TestTime.UTC(), after.CommitID, after.EnvName, after.TestName, q.limit()+1)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test cases: %v", err)
+	}
+	page := &models.DBTestCasesPage{TestCases: testCases}
+	if len(testCases) > q.limit() {
+		page.TestCases = testCases[:q.limit()]
+		last := page.TestCases[len(page.TestCases)-1]
+		page.NextCursor = cursor{TestTime: last.TestTime, CommitID: last.CommitID, EnvName: last.EnvName, TestName: last.TestName}.encode()
+	}
+	return page, nil
+}
+
+// lastnData is the CTE of the recent non-skipped test cases of the environment ($n) matching the filter (from $n+1)
+func lastnData(f Filter, n int) string {
+	return fmt.Sprintf(`
+	lastn_data AS (
 		SELECT * FROM db_test_cases
-		WHERE Result != 'skip' AND EnvName = '%s' AND TestTime >= NOW() - INTERVAL '90 days'
-	`, viewName, env)
+		WHERE Result != 'skip' AND EnvName = $%d AND %s
+	)`, n, f.pgWhere(n+1))
+}
 
-	_, err := m.db.Exec(createView)
-	return err
+// lastnEnvData is the CTE of the recent runs of the environment ($n) matching the filter (from $n+1)
+func lastnEnvData(f Filter, n int) string {
+	return fmt.Sprintf(`
+	lastn_env_data AS (
+		SELECT *
+		FROM db_environment_tests
+		WHERE EnvName = $%d AND %s
+	)`, n, f.pgWhere(n+1))
 }
 
-// GetTestCharts writes the individual test chart data to a map with the keys flakeByDay and flakeByWeek
-func (m *Postgres) GetTestCharts(env string, test string) (map[string]interface{}, error) {
-	start := time.Now()
+// testDaysData is the CTE of the daily runs, fails and durations of the non-skipped test cases of the environment ($n)
+// matching the filter (from $n+1). It reads the daily rollups unless the filter needs the raw rows
+func testDaysData(f Filter, n int) string {
+	if f.rollup() {
+		return fmt.Sprintf(`
+	test_days AS (
+		SELECT TestName, Day::timestamp AS Day, SUM(Runs) AS Runs, SUM(Fails) AS Fails, SUM(TotalDuration) AS TotalDuration
+		FROM db_test_cases_daily
+		WHERE EnvName = $%d AND %s
+		GROUP BY TestName, Day
+		HAVING SUM(Runs) > 0
+	)`, n, f.pgRollupWhere(n+1))
+	}
+	return fmt.Sprintf(`
+	test_days AS (
+		SELECT TestName, DATE_TRUNC('day', TestTime) AS Day, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails, SUM(Duration) AS TotalDuration
+		FROM db_test_cases
+		WHERE Result != 'skip' AND EnvName = $%d AND %s
+		GROUP BY TestName, Day
+	)`, n, f.pgWhere(n+1))
+}
 
+// baselineDaysData is the CTE of the daily run count and average duration of the tests on the environment ($3), or on every environment if $3 is empty,
+// on the days before the recent window ($1) without failed runs matching the filter (from $6). It reads the daily rollups unless the filter needs the raw rows
+func baselineDaysData(f Filter) string {
+	if f.rollup() {
+		return `
+	baseline_days AS (
+		SELECT EnvName, TestName, Day, SUM(Runs) AS Runs, SUM(TotalDuration) / SUM(Runs) AS Duration
+		FROM db_test_cases_daily
+		WHERE ($3::text = '' OR EnvName = $3) AND Day < $1::timestamp AND ` + f.pgRollupWhere(6) + `
+		GROUP BY EnvName, TestName, Day
+		HAVING SUM(Runs) > 0 AND SUM(Fails) = 0
+	)`
+	}
+	return `
+	baseline_days AS (
+		SELECT EnvName, TestName, DATE_TRUNC('day', TestTime) AS Day, COUNT(*) AS Runs, AVG(Duration) AS Duration
+		FROM db_test_cases
+		WHERE Result != 'skip' AND ($3::text = '' OR EnvName = $3) AND TestTime < $1 AND ` + f.pgWhere(6) + `
+		GROUP BY EnvName, TestName, Day
+		HAVING COUNT(CASE WHEN Result = 'fail' THEN 1 END) = 0
+	)`
+}
+
+// envDaysData is the CTE of the daily runs, fails, passes and durations of the environment ($n), or of every environment if $n is empty,
+// matching the filter (from $n+1). It reads the daily rollups unless the filter needs the raw rows
+func envDaysData(f Filter, n int) string {
+	if f.rollup() {
+		return fmt.Sprintf(`
+	env_days AS (
+		SELECT EnvName, Day::timestamp AS Day, SUM(Runs) AS Runs, SUM(NumberOfFail) AS NumberOfFail, SUM(NumberOfPass) AS NumberOfPass, SUM(TotalDuration) AS TotalDuration
+		FROM db_environment_tests_daily
+		WHERE ($%[1]d::text = '' OR EnvName = $%[1]d) AND %[2]s
+		GROUP BY EnvName, Day
+		HAVING SUM(Runs) > 0
+	)`, n, f.pgRollupWhere(n+1))
+	}
+	return fmt.Sprintf(`
+	env_days AS (
+		SELECT EnvName, DATE_TRUNC('day', TestTime) AS Day, COUNT(*) AS Runs, SUM(NumberOfFail) AS NumberOfFail, SUM(NumberOfPass) AS NumberOfPass, SUM(TotalDuration) AS TotalDuration
+		FROM db_environment_tests
+		WHERE ($%[1]d::text = '' OR EnvName = $%[1]d) AND %[2]s
+		GROUP BY EnvName, Day
+	)`, n, f.pgWhere(n+1))
+}
+
+// validateEnv checks the environment has results stored for the project
+func (m *Postgres) validateEnv(ctx context.Context, project, env string) error {
 	var validEnvs []string
-	err := m.db.Select(&validEnvs, "SELECT DISTINCT EnvName FROM db_environment_tests")
+	err := m.db.SelectContext(ctx, &validEnvs, "SELECT DISTINCT EnvName FROM db_environment_tests WHERE Project = $1", project)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for list of valid environments: %v", err)
+		return fmt.Errorf("failed to execute SQL query for list of valid environments: %v", err)
 	}
-	isValidEnv := false
 	for _, e := range validEnvs {
 		if env == e {
-			isValidEnv = true
+			return nil
 		}
 	}
-	if !isValidEnv {
-		return nil, fmt.Errorf("invalid environment. Not found in database: %v", err)
-	}
+	return fmt.Errorf("invalid environment. Not found in database: %q", env)
+}
 
-	viewName := fmt.Sprintf("\"lastn_data_%s\"", env)
-	err = m.createMaterializedView(env, viewName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for view creation: %v", err)
-	}
+// GetTestCharts writes the individual test chart data to a map with the keys flakeByDay and flakeByWeek
+func (m *Postgres) GetTestCharts(ctx context.Context, f Filter, env, test string) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
 
-	log.Printf("\nduration metric: took %f seconds to execute SQL query for refreshing materialized view since start of handler", time.Since(start).Seconds())
+	if err := m.validateEnv(ctx, f.Project, env); err != nil {
+		return nil, err
+	}
 
-	// Groups the datetimes together by date, calculating flake percentage and aggregating the individual results/durations for each date
-	sqlQuery := fmt.Sprintf(`
-	SELECT
-	DATE_TRUNC('day', TestTime) AS StartOfDate,
-	AVG(Duration) AS AvgDuration,
-	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result || ': ' || Duration, ', ') AS CommitResultsAndDurations
-	FROM %s 
-	WHERE TestName = $1
-	GROUP BY StartOfDate
-	ORDER BY StartOfDate DESC
-	`, viewName)
+	// Groups the days together by period, calculating flake percentage and average duration from the daily runs
+	// and aggregating the individual results and durations of the runs still stored into a json array
+	testChartQuery := func(period string) string {
+		return `
+	WITH` + testDaysData(f, 2) + `,` + lastnData(f, 2) + `, points AS (
+		SELECT DATE_TRUNC('` + period + `', Day) AS StartOfDate,
+		SUM(TotalDuration) / SUM(Runs) AS AvgDuration,
+		ROUND(SUM(Fails) * 100.0 / SUM(Runs), 2) AS FlakePercentage
+		FROM test_days
+		WHERE TestName = $1
+		GROUP BY StartOfDate
+	), commits AS (
+		SELECT DATE_TRUNC('` + period + `', TestTime) AS StartOfDate,
+		JSON_AGG(JSON_BUILD_OBJECT('commit', CommitID, 'result', Result, 'duration', Duration) ORDER BY TestTime) AS Commits
+		FROM lastn_data
+		WHERE TestName = $1
+		GROUP BY StartOfDate
+	)
+	SELECT p.StartOfDate, p.AvgDuration, p.FlakePercentage, COALESCE(c.Commits, '[]') AS Commits
+	FROM points p
+	LEFT JOIN commits c ON c.StartOfDate = p.StartOfDate
+	ORDER BY p.StartOfDate DESC
+	`
+	}
 
 	var flakeByDay []models.DBTestRateAndDuration
-	err = m.db.Select(&flakeByDay, sqlQuery, test)
+	err := m.db.SelectContext(ctx, &flakeByDay, testChartQuery("day"), f.args(test, env)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake rate and duration by day chart: %v", err)
 	}
-
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake rate and duration by day chart since start of handler", time.Since(start).Seconds())
 
-	// Groups the datetimes together by week, calculating flake percentage and aggregating the individual results/durations for each date
-	sqlQuery = fmt.Sprintf(`
-	SELECT
-	DATE_TRUNC('week', TestTime) AS StartOfDate,
-	AVG(Duration) AS AvgDuration,
-	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result || ': ' || Duration, ', ') AS CommitResultsAndDurations
-	FROM %s 
-	WHERE TestName = $1
-	GROUP BY StartOfDate
-	ORDER BY StartOfDate DESC
-	`, viewName)
 	var flakeByWeek []models.DBTestRateAndDuration
-	err = m.db.Select(&flakeByWeek, sqlQuery, test)
+	err = m.db.SelectContext(ctx, &flakeByWeek, testChartQuery("week"), f.args(test, env)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake rate and duration by week chart: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake rate and duration by week chart since start of handler", time.Since(start).Seconds())
 
-	// Groups the datetimes together by month, calculating flake percentage and aggregating the individual results/durations for each date
-	sqlQuery = fmt.Sprintf(`
-	SELECT
-	DATE_TRUNC('month', TestTime) AS StartOfDate,
-	AVG(Duration) AS AvgDuration,
-	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result || ': ' || Duration, ', ') AS CommitResultsAndDurations
-	FROM %s 
-	WHERE TestName = $1
-	GROUP BY StartOfDate
-	ORDER BY StartOfDate DESC
-	`, viewName)
 	var flakeByMonth []models.DBTestRateAndDuration
-	err = m.db.Select(&flakeByMonth, sqlQuery, test)
+	err = m.db.SelectContext(ctx, &flakeByMonth, testChartQuery("month"), f.args(test, env)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake rate and duration by month chart: %v", err)
 	}
@@ -249,44 +516,63 @@ func (m *Postgres) GetTestCharts(env string, test string) (map[string]interface{
 	return data, nil
 }
 
-// GetEnvCharts writes the overall environment charts to a map with the keys recentFlakePercentTable, flakeRateByWeek, flakeRateByDay, and countsAndDurations
-func (m *Postgres) GetEnvCharts(env string, testsInTop int) (map[string]interface{}, error) {
+// GetTestHistory writes the results of the test on the last n commits to a map with the keys history and firstFailure
+func (m *Postgres) GetTestHistory(ctx context.Context, f Filter, env, test string, n int) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 	start := time.Now()
 
-	var validEnvs []string
-	err := m.db.Select(&validEnvs, "SELECT DISTINCT EnvName FROM db_environment_tests")
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for list of valid environments: %v", err)
-	}
-	isValidEnv := false
-	for _, e := range validEnvs {
-		if env == e {
-			isValidEnv = true
-		}
-	}
-	if !isValidEnv {
-		return nil, fmt.Errorf("invalid environment. Not found in database: %v", err)
+	if err := m.validateEnv(ctx, f.Project, env); err != nil {
+		return nil, err
 	}
 
-	viewName := fmt.Sprintf("\"lastn_data_%s\"", env)
-	err = m.createMaterializedView(env, viewName)
+	// Orders the runs by the position of their commit in the history, runs without one are ordered by time before the ones with one
+	sqlQuery := `
+	SELECT t.CommitID, e.ParentCommit, e.CommitOrder, t.TestTime, t.Result, t.Duration
+	FROM (
+		SELECT * FROM db_test_cases
+		WHERE Result != 'skip' AND TestName = $1 AND EnvName = $2 AND ` + f.pgWhere(4) + `
+	) t
+	JOIN db_environment_tests e ON e.Project = t.Project AND e.CommitID = t.CommitID AND e.EnvName = t.EnvName
+	ORDER BY e.CommitOrder DESC, t.TestTime DESC
+	LIMIT $3
+	`
+	var history []models.DBTestHistory
+	err := m.db.SelectContext(ctx, &history, sqlQuery, f.args(test, env, n)...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute SQL query for view creation: %v", err)
+		return nil, fmt.Errorf("failed to execute SQL query for test history: %v", err)
+	}
+	reverseHistory(history)
+
+	data := map[string]interface{}{
+		"history":      history,
+		"firstFailure": firstFailure(history),
 	}
+	log.Printf("\nduration metric: took %f seconds to gather test history since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
 
-	log.Printf("\nduration metric: took %f seconds to execute SQL query for refreshing materialized view since start of handler", time.Since(start).Seconds())
+// GetEnvCharts writes the overall environment charts to a map with the keys recentFlakePercentTable, flakeRateByWeek, flakeRateByDay, and countsAndDurations
+func (m *Postgres) GetEnvCharts(ctx context.Context, f Filter, env string, testsInTop int) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+
+	if err := m.validateEnv(ctx, f.Project, env); err != nil {
+		return nil, err
+	}
 
 	// Number of days to use to look for "flaky-est" tests.
-	const dateRange = 15
-
-	// This query first makes a temp table containing the $1 (30) most recent dates
-	// Then it computes the recentCutoff and prevCutoff (15th most recent and 30th most recent dates)
-	// Then we calculate the flake rate and the flake rate growth
-	// for the 15 most recent days and the 15 days following that
-	sqlQuer := fmt.Sprintf(`
-	WITH dates AS (
-		SELECT DISTINCT DATE_TRUNC('day', TestTime) AS Date
-		FROM %s
+	dateRange := f.window()
+
+	// This query first makes a temp table containing the $1 (30) most recent dates with runs
+	// Then it computes the recentCutoff and prevCutoff (15th most recent and 30th most recent dates), all of the data is recent if there are fewer dates
+	// Then we calculate the flake rate, the flake rate growth and the number of runs and fails from the daily runs
+	// for the 15 most recent days and the 15 days following that, leaving out the tests with fewer than $4 recent runs
+	sqlQuer := `
+	WITH` + testDaysData(f, 5) + `, dates AS (
+		SELECT DISTINCT Day AS Date
+		FROM test_days
 		ORDER BY Date DESC
 		LIMIT $1
 	), recentCutoff AS (
@@ -301,25 +587,51 @@ func (m *Postgres) GetEnvCharts(env string, testsInTop int) (map[string]interfac
 		ORDER BY Date DESC
 		OFFSET $3
 		LIMIT 1
+	), cutoffs AS (
+		SELECT COALESCE((SELECT Date FROM recentCutoff), '-infinity') AS RecentCutoff,
+		COALESCE((SELECT Date FROM prevCutoff), '-infinity') AS PrevCutoff
 	), temp AS (
 	SELECT TestName,
-	ROUND(COALESCE(AVG(CASE WHEN TestTime > (SELECT Date FROM recentCutoff) THEN CASE WHEN Result = 'fail' THEN 1 ELSE 0 END END) * 100, 0), 2) AS RecentFlakePercentage,
-	ROUND(COALESCE(AVG(CASE WHEN TestTime <= (SELECT Date FROM recentCutoff) AND TestTime > (SELECT Date FROM prevCutoff) THEN CASE WHEN Result = 'fail' THEN 1 ELSE 0 END END) * 100, 0), 2) AS PrevFlakePercentage
-	FROM %s
+	ROUND(COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN Fails END) * 100.0 / NULLIF(SUM(CASE WHEN Day >= c.RecentCutoff THEN Runs END), 0), 0), 2) AS RecentFlakePercentage,
+	ROUND(COALESCE(SUM(CASE WHEN Day < c.RecentCutoff AND Day >= c.PrevCutoff THEN Fails END) * 100.0 / NULLIF(SUM(CASE WHEN Day < c.RecentCutoff AND Day >= c.PrevCutoff THEN Runs END), 0), 0), 2) AS PrevFlakePercentage,
+	COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN Runs END), 0) AS RecentRuns,
+	COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN Fails END), 0) AS RecentFails
+	FROM test_days, cutoffs c
 	GROUP BY TestName
-	ORDER BY RecentFlakePercentage DESC
 	)
-	SELECT TestName, RecentFlakePercentage, RecentFlakePercentage - PrevFlakePercentage AS GrowthRate
+	SELECT TestName, RecentFlakePercentage, RecentFlakePercentage - PrevFlakePercentage AS GrowthRate, RecentRuns, RecentFails
 	FROM temp
-	ORDER BY RecentFlakePercentage DESC;
-	`, viewName, viewName)
+	WHERE RecentRuns >= $4
+	ORDER BY RecentFlakePercentage DESC, RecentRuns DESC;
+	`
 	var flakeRates []models.DBFlakeRow
-	err = m.db.Select(&flakeRates, sqlQuer, 2*dateRange, dateRange-1, 2*dateRange-1)
+	err := m.db.SelectContext(ctx, &flakeRates, sqlQuer, f.args(2*dateRange, dateRange-1, 2*dateRange-1, f.minRuns(), env)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake table: %v", err)
 	}
+	for i := range flakeRates {
+		flakeRates[i].ConfidenceLow, flakeRates[i].ConfidenceHigh = wilsonInterval(flakeRates[i].RecentFails, flakeRates[i].RecentRuns)
+	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake table since start of handler", time.Since(start).Seconds())
 
+	// Gets the results in commit order of the tests of the table that failed, to tell flaky tests from broken ones.
+	// The tests that did not fail are stable, so their results are not read
+	sqlQuer = `
+	WITH` + lastnData(f, 2) + `
+	SELECT t.TestName, t.CommitID, t.Result
+	FROM lastn_data t
+	JOIN db_environment_tests e ON e.Project = t.Project AND e.CommitID = t.CommitID AND e.EnvName = t.EnvName
+	WHERE t.TestName = ANY($1) AND t.TestName IN (SELECT TestName FROM lastn_data WHERE Result = 'fail')
+	ORDER BY t.TestName, e.CommitOrder, t.TestTime
+	`
+	var results []testResult
+	err = m.db.SelectContext(ctx, &results, sqlQuer, f.args(pq.Array(flakeTableTests(flakeRates)), env)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for flakiness scores: %v", err)
+	}
+	setFlakiness(flakeRates, results)
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for flakiness scores since start of handler", time.Since(start).Seconds())
+
 	var topTestNames []string
 	for _, row := range flakeRates {
 		topTestNames = append(topTestNames, row.TestName)
@@ -330,84 +642,90 @@ func (m *Postgres) GetEnvCharts(env string, testsInTop int) (map[string]interfac
 
 	// Gets the data on just the top ten previously calculated and aggregates flake rates and results per date
 	sqlQuer = fmt.Sprintf(`
-	WITH lastn_data_top AS (
-		SELECT *
-		FROM %s
-		WHERE TestName IN ('%s')
+	WITH %s, %s, commits AS (
+		SELECT TestName, DATE_TRUNC('day', TestTime) AS StartOfDate,
+		JSON_AGG(JSON_BUILD_OBJECT('commit', CommitID, 'result', Result, 'duration', Duration) ORDER BY TestTime) AS Commits
+		FROM lastn_data
+		WHERE TestName = ANY($1)
+		GROUP BY TestName, StartOfDate
 	)
-	SELECT TestName, 
-	DATE_TRUNC('day', TestTime) AS StartOfDate,
-	COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result, ', ') AS CommitResults
-	FROM lastn_data_top
-	GROUP BY TestName, StartOfDate
+	SELECT d.TestName, 
+	d.Day AS StartOfDate,
+	d.Fails * 100.0 / d.Runs AS FlakePercentage,
+	COALESCE(c.Commits, '[]') AS Commits
+	FROM test_days d
+	LEFT JOIN commits c ON c.TestName = d.TestName AND c.StartOfDate = d.Day
+	WHERE d.TestName = ANY($1)
 	ORDER BY StartOfDate DESC
-	`, viewName,
-		strings.Join(topTestNames, "', '"))
+	`, testDaysData(f, 2), lastnData(f, 2))
 	var flakeRateByDay []models.DBFlakeBy
-	err = m.db.Select(&flakeRateByDay, sqlQuer)
+	err = m.db.SelectContext(ctx, &flakeRateByDay, sqlQuer, f.args(pq.Array(topTestNames), env)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for by day flake chart: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for day flake chart since start of handler", time.Since(start).Seconds())
 
 	// Filters to get the top flakiest in the past week, calculating flake rate per week for those tests
-	sqlQuer = fmt.Sprintf(`
-	WITH recent_week AS (
-		SELECT MAX (DATE_TRUNC('week', TestTime)) AS weekCutoff
-		FROM %s
-	),
-	recent_week_data AS (
-		SELECT * 
-		FROM %s 
-		WHERE TestTime >= (SELECT weekCutoff FROM recent_week)
+	sqlQuer = `
+	WITH` + testDaysData(f, 3) + `,` + lastnData(f, 3) + `, recent_week AS (
+		SELECT MAX (DATE_TRUNC('week', Day)) AS weekCutoff
+		FROM test_days
 	),
 	top_flakiest AS (
-		SELECT TestName, COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0) AS RecentFlakePercentage
-		FROM recent_week_data
+		SELECT TestName, SUM(Fails) * 100.0 / SUM(Runs) AS RecentFlakePercentage
+		FROM test_days
+		WHERE Day >= (SELECT weekCutoff FROM recent_week)
 		GROUP BY TestName
+		HAVING SUM(Runs) >= $2
 		ORDER BY RecentFlakePercentage DESC
 		LIMIT $1
 	),
-	top_flakiest_data AS (
-		SELECT * FROM %s 
+	points AS (
+		SELECT TestName, DATE_TRUNC('week', Day) AS StartOfDate,
+		ROUND(SUM(Fails) * 100.0 / SUM(Runs), 2) AS FlakePercentage
+		FROM test_days
 		WHERE TestName IN (SELECT TestName FROM top_flakiest)
+		GROUP BY TestName, StartOfDate
+	),
+	commits AS (
+		SELECT TestName, DATE_TRUNC('week', TestTime) AS StartOfDate,
+		JSON_AGG(JSON_BUILD_OBJECT('commit', CommitID, 'result', Result, 'duration', Duration) ORDER BY TestTime) AS Commits
+		FROM lastn_data
+		WHERE TestName IN (SELECT TestName FROM top_flakiest)
+		GROUP BY TestName, StartOfDate
 	)
-	SELECT TestName,
-	DATE_TRUNC('week', TestTime) AS StartOfDate,
-	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage,
-	STRING_AGG(CommitID || ': ' || Result, ', ') AS CommitResults
-	FROM top_flakiest_data
-	GROUP BY TestName, StartOfDate
-	ORDER BY StartOfDate DESC;
-	`, viewName, viewName, viewName)
+	SELECT p.TestName, p.StartOfDate, p.FlakePercentage, COALESCE(c.Commits, '[]') AS Commits
+	FROM points p
+	LEFT JOIN commits c ON c.TestName = p.TestName AND c.StartOfDate = p.StartOfDate
+	ORDER BY p.StartOfDate DESC;
+	`
 	var flakeRateByWeek []models.DBFlakeBy
-	err = m.db.Select(&flakeRateByWeek, sqlQuer, testsInTop)
+	err = m.db.SelectContext(ctx, &flakeRateByWeek, sqlQuer, f.args(testsInTop, f.minRuns(), env)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for by week flake chart: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for flake by week chart since start of handler", time.Since(start).Seconds())
 
-	// Filters out data prior to 90 days and with the incorrect environment
-	// Then calculates for each date aggregates the duration and number of tests, calculating the average for both
+	// Calculates for each date the average duration and number of tests from the daily runs,
+	// aggregating the number of tests and duration of the runs still stored into a json array
 	sqlQuer = `
-	WITH lastn_env_data AS (
-		SELECT *
-		FROM db_environment_tests
-		WHERE EnvName = $1 AND TestTime >= NOW() - INTERVAL '90 days'
+	WITH` + envDaysData(f, 1) + `,` + lastnEnvData(f, 1) + `, commits AS (
+		SELECT DATE_TRUNC('day', TestTime) AS StartOfDate,
+		JSON_AGG(JSON_BUILD_OBJECT('commit', CommitID, 'testCount', NumberOfPass + NumberOfFail, 'duration', TotalDuration) ORDER BY TestTime) AS Commits
+		FROM lastn_env_data
+		GROUP BY StartOfDate
 	)
 	SELECT
-	DATE_TRUNC('day', TestTime) AS StartOfDate,
-	AVG(NumberOfPass + NumberOfFail) AS TestCount,
-	AVG(TotalDuration) AS Duration,
-	STRING_AGG(CommitID || ': ' || (NumberOfPass + NumberOfFail), ', ') AS CommitCounts,
-	STRING_AGG(CommitID || ': ' || TotalDuration, ', ') AS CommitDurations
-	FROM lastn_env_data 
-	GROUP BY StartOfDate
+	d.Day AS StartOfDate,
+	(d.NumberOfPass + d.NumberOfFail) * 1.0 / d.Runs AS TestCount,
+	d.TotalDuration / d.Runs AS Duration,
+	COALESCE(c.Commits, '[]') AS Commits
+	FROM env_days d
+	LEFT JOIN commits c ON c.StartOfDate = d.Day
 	ORDER BY StartOfDate DESC
 	`
 	var countsAndDurations []models.DBEnvDuration
-	err = m.db.Select(&countsAndDurations, sqlQuer, env)
+	err = m.db.SelectContext(ctx, &countsAndDurations, sqlQuer, f.args(env)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for environment test count and duration chart: %v", err)
 	}
@@ -423,39 +741,315 @@ func (m *Postgres) GetEnvCharts(env string, testsInTop int) (map[string]interfac
 	return data, nil
 }
 
-// GetOverview writes the overview charts to a map with the keys summaryAvgFail and summaryTable
-func (m *Postgres) GetOverview() (map[string]interface{}, error) {
+// GetDurationRegressions writes the tests that got slower on the environment, or on every environment if env is empty, to a map with the key durationRegressions
+func (m *Postgres) GetDurationRegressions(ctx context.Context, f Filter, env string, ratio float64) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
 	start := time.Now()
-	// Filters out old data and calculates the average number of failures and average duration per day per environment
+
+	regressions, err := m.durationRegressions(ctx, f, env, ratio)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{
+		"durationRegressions": regressions,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather duration regressions since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// durationRegressions finds the tests whose median duration in the last window days is ratio times their baseline before,
+// and the commit the duration shifted at
+func (m *Postgres) durationRegressions(ctx context.Context, f Filter, env string, ratio float64) ([]models.DBDurationRegression, error) {
+	// Compares the median duration of the passing runs of each test in the recent window to the median of its daily average duration before it,
+	// the days with failed runs are left out of the baseline as those runs may have stopped early or timed out
 	sqlQuery := `
-	SELECT DATE_TRUNC('day', TestTime) AS StartOfDate, EnvName, AVG(NumberOfFail) AS AvgFailedTests, AVG(TotalDuration) AS AvgDuration
+	WITH ` + baselineDaysData(f) + `, recent AS (
+		SELECT EnvName, TestName, Duration FROM db_test_cases
+		WHERE Result = 'pass' AND ($3::text = '' OR EnvName = $3) AND TestTime >= $1 AND ` + f.pgWhere(6) + `
+	), medians AS (
+		SELECT EnvName, TestName,
+		PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY Duration) FILTER (WHERE NOT Recent) AS BaselineMedian,
+		PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY Duration) FILTER (WHERE Recent) AS RecentMedian,
+		COALESCE(SUM(Runs) FILTER (WHERE NOT Recent), 0) AS BaselineRuns,
+		COALESCE(SUM(Runs) FILTER (WHERE Recent), 0) AS RecentRuns
+		FROM (
+			SELECT EnvName, TestName, Duration, Runs, FALSE AS Recent FROM baseline_days
+			UNION ALL
+			SELECT EnvName, TestName, Duration, 1 AS Runs, TRUE AS Recent FROM recent
+		) data
+		GROUP BY EnvName, TestName
+	)
+	SELECT EnvName, TestName, BaselineMedian, RecentMedian, BaselineRuns, RecentRuns
+	FROM medians
+	WHERE BaselineRuns >= $2 AND RecentRuns >= $2 AND RecentMedian >= BaselineMedian * $4 AND RecentMedian - BaselineMedian >= $5
+	ORDER BY RecentMedian / NULLIF(BaselineMedian, 0) DESC NULLS FIRST
+	`
+	var regressions []models.DBDurationRegression
+	err := m.db.SelectContext(ctx, &regressions, sqlQuery, f.args(regressionStart(f), f.minRuns(), env, ratio, minRegressionSeconds)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for duration regressions: %v", err)
+	}
+	if len(regressions) == 0 {
+		return regressions, nil
+	}
+	setRatios(regressions)
+
+	// Gets the durations of the regressed tests in commit order to find the commit they shifted at
+	sqlQuery = `
+	SELECT t.EnvName, t.TestName, t.CommitID, t.Duration
+	FROM (
+		SELECT * FROM db_test_cases
+		WHERE Result = 'pass' AND (EnvName, TestName) IN (SELECT * FROM UNNEST($1::text[], $2::text[])) AND ` + f.pgWhere(3) + `
+	) t
+	JOIN db_environment_tests e ON e.Project = t.Project AND e.CommitID = t.CommitID AND e.EnvName = t.EnvName
+	ORDER BY t.EnvName, t.TestName, e.CommitOrder, t.TestTime
+	`
+	envs := make([]string, len(regressions))
+	tests := make([]string, len(regressions))
+	for i, r := range regressions {
+		envs[i], tests[i] = r.EnvName, r.TestName
+	}
+	var durations []testDuration
+	if err := m.db.SelectContext(ctx, &durations, sqlQuery, f.args(pq.Array(envs), pq.Array(tests))...); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for durations of the regressed tests: %v", err)
+	}
+	setShiftCommits(regressions, durations)
+	return regressions, nil
+}
+
+// GetRunStats returns the stats of the post-merge runs of the environment and branch of run between since and run
+func (m *Postgres) GetRunStats(ctx context.Context, run models.DBEnvironmentTest, since time.Time) (*models.DBRunStats, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	stats := &models.DBRunStats{
+		PrevResults: map[string]string{},
+		TestRuns:    map[string]int{},
+		TestFails:   map[string]int{},
+	}
+
+	var prevCommits []string
+	err := m.db.SelectContext(ctx, &prevCommits, `
+	SELECT CommitID FROM db_environment_tests
+	WHERE Project = $1 AND EnvName = $2 AND Branch = $3 AND PR = '' AND CommitID != $4 AND TestTime < $5
+	ORDER BY TestTime DESC
+	LIMIT 1
+	`, run.Project, run.EnvName, run.Branch, run.CommitID, run.TestTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for previous run: %v", err)
+	}
+	if len(prevCommits) > 0 {
+		stats.PrevCommit = prevCommits[0]
+		var prevResults []struct {
+			TestName string
+			Result   string
+		}
+		err = m.db.SelectContext(ctx, &prevResults, `SELECT TestName, Result FROM db_test_cases WHERE Project = $1 AND CommitID = $2 AND EnvName = $3`, run.Project, stats.PrevCommit, run.EnvName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute SQL query for previous run results: %v", err)
+		}
+		for _, r := range prevResults {
+			stats.PrevResults[r.TestName] = r.Result
+		}
+	}
+
+	var testCounts []struct {
+		TestName string
+		Runs     int
+		Fails    int
+	}
+	err = m.db.SelectContext(ctx, &testCounts, `
+	SELECT TestName, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails
+	FROM db_test_cases
+	WHERE Project = $1 AND EnvName = $2 AND Branch = $3 AND COALESCE(PR, '') = '' AND Result != 'skip' AND TestTime >= $4 AND TestTime < $5
+	GROUP BY TestName
+	`, run.Project, run.EnvName, run.Branch, since.UTC(), run.TestTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test failure counts: %v", err)
+	}
+	for _, c := range testCounts {
+		stats.TestRuns[c.TestName] = c.Runs
+		stats.TestFails[c.TestName] = c.Fails
+	}
+
+	err = m.db.GetContext(ctx, stats, `
+	SELECT COUNT(*) AS EnvRuns, COALESCE(AVG(NumberOfFail), 0) AS AvgFails
 	FROM db_environment_tests
-	WHERE TestTime >= NOW() - INTERVAL '90 days'
-	GROUP BY StartOfDate, EnvName
-	ORDER BY StartOfDate, EnvName;
+	WHERE Project = $1 AND EnvName = $2 AND Branch = $3 AND PR = '' AND TestTime >= $4 AND TestTime < $5
+	`, run.Project, run.EnvName, run.Branch, since.UTC(), run.TestTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for environment failure average: %v", err)
+	}
+	return stats, nil
+}
+
+// GetTestFailures returns the number of runs and failures of every test on every environment
+func (m *Postgres) GetTestFailures(ctx context.Context, f Filter) ([]models.DBTestFailures, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	sqlQuery := `
+	SELECT TestName, EnvName, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails,
+	JSON_AGG(CommitID ORDER BY TestTime DESC) FILTER (WHERE Result = 'fail') AS FailedCommits
+	FROM db_test_cases
+	WHERE Result != 'skip' AND ` + f.pgWhere(1) + `
+	GROUP BY TestName, EnvName
+	ORDER BY TestName, EnvName
+	`
+	var failures []models.DBTestFailures
+	if err := m.db.SelectContext(ctx, &failures, sqlQuery, f.args()...); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for test failures: %v", err)
+	}
+	return failures, nil
+}
+
+// GetOwners writes the flake rates and failures of the tests per owner to a map with the keys owners and ownerTests
+func (m *Postgres) GetOwners(ctx context.Context, f Filter) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+
+	// Calculates the flake rate of all of the tests of each owner, tests without owners are grouped under ''
+	sqlQuery := `
+	SELECT Owner, COUNT(DISTINCT TestName) AS Tests, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails,
+	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage
+	FROM db_test_cases
+	WHERE Result != 'skip' AND ` + f.pgWhere(1) + `
+	GROUP BY Owner
+	ORDER BY FlakePercentage DESC
+	`
+	var owners []models.DBOwnerRow
+	if err := m.db.SelectContext(ctx, &owners, sqlQuery, f.args()...); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for owners table: %v", err)
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for owners table since start of handler", time.Since(start).Seconds())
+
+	// Calculates the flake rate of each failing test of each owner on each environment
+	sqlQuery = `
+	SELECT Owner, TestName, EnvName, COUNT(*) AS Runs, COUNT(CASE WHEN Result = 'fail' THEN 1 END) AS Fails,
+	ROUND(COALESCE(AVG(CASE WHEN Result = 'fail' THEN 1 ELSE 0 END) * 100, 0), 2) AS FlakePercentage
+	FROM db_test_cases
+	WHERE Result != 'skip' AND ` + f.pgWhere(1) + `
+	GROUP BY Owner, TestName, EnvName
+	HAVING COUNT(CASE WHEN Result = 'fail' THEN 1 END) > 0
+	ORDER BY Owner, FlakePercentage DESC
 	`
+	var ownerTests []models.DBOwnerTest
+	if err := m.db.SelectContext(ctx, &ownerTests, sqlQuery, f.args()...); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for owner tests table: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"owners":     owners,
+		"ownerTests": ownerTests,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather owner data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
 
+// Prune deletes the rows of the runs before the given time, they are kept in the daily rollups
+func (m *Postgres) Prune(ctx context.Context, before time.Time) (*models.DBPruneResult, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SQL transaction: %v", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // rollback after commit is a no-op
+
+	before = before.UTC()
+	var pruned models.DBPruneResult
+	res, err := tx.ExecContext(ctx, `DELETE FROM db_test_cases WHERE TestTime < $1`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete test cases: %v", err)
+	}
+	if pruned.TestCases, err = res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to count deleted test cases: %v", err)
+	}
+	res, err = tx.ExecContext(ctx, `DELETE FROM db_environment_tests WHERE TestTime < $1`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete environment tests: %v", err)
+	}
+	if pruned.EnvironmentTests, err = res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to count deleted environment tests: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit prune transaction: %v", err)
+	}
+	return &pruned, nil
+}
+
+// GetDailyTrend writes the daily runs, flake rate and duration of a test on an environment, or of the environment if test is empty,
+// to a map with the key dailyTrend. The fails and flake rate of an environment are the ones of all of its tests.
+// The daily rollups keep the pruned runs, so the trend covers the whole history unless the filter has a From.
+func (m *Postgres) GetDailyTrend(ctx context.Context, f Filter, env, test string) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+	if f.From.IsZero() {
+		f.From = time.Unix(0, 0)
+	}
+
+	var sqlQuery string
+	var args []interface{}
+	if test == "" {
+		sqlQuery = `
+		WITH` + envDaysData(f, 1) + `
+		SELECT Day, Runs, NumberOfFail AS Fails,
+		ROUND(COALESCE(NumberOfFail * 100.0 / NULLIF(NumberOfFail + NumberOfPass, 0), 0), 2) AS FlakePercentage,
+		TotalDuration / Runs AS AvgDuration
+		FROM env_days
+		ORDER BY Day
+		`
+		args = f.args(env)
+	} else {
+		sqlQuery = `
+		WITH` + testDaysData(f, 2) + `
+		SELECT Day, Runs, Fails,
+		ROUND(Fails * 100.0 / Runs, 2) AS FlakePercentage,
+		TotalDuration / Runs AS AvgDuration
+		FROM test_days
+		WHERE TestName = $1
+		ORDER BY Day
+		`
+		args = f.args(test, env)
+	}
+	var trend []models.DBDailyTrend
+	if err := m.db.SelectContext(ctx, &trend, sqlQuery, args...); err != nil {
+		return nil, fmt.Errorf("failed to execute SQL query for daily trend: %v", err)
+	}
+	data := map[string]interface{}{
+		"dailyTrend": trend,
+	}
+	log.Printf("\nduration metric: took %f seconds to gather daily trend data since start of handler\n\n", time.Since(start).Seconds())
+	return data, nil
+}
+
+// GetOverview writes the overview charts to a map with the keys summaryAvgFail, summaryTable and durationRegressions
+func (m *Postgres) GetOverview(ctx context.Context, f Filter) (map[string]interface{}, error) {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	start := time.Now()
+	// Calculates the average number of failures and average duration per day per environment from the daily runs
+	sqlQuery := `
+	WITH` + envDaysData(f, 1) + `
+	SELECT Day AS StartOfDate, EnvName, NumberOfFail * 1.0 / Runs AS AvgFailedTests, TotalDuration / Runs AS AvgDuration
+	FROM env_days
+	ORDER BY StartOfDate, EnvName;
+	`
 	var summaryAvgFail []models.DBSummaryAvgFail
-	err := m.db.Select(&summaryAvgFail, sqlQuery)
+	err := m.db.SelectContext(ctx, &summaryAvgFail, sqlQuery, f.args("")...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for summary chart: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for summary duration and failure charts since start of handler", time.Since(start).Seconds())
 
 	// Number of days to use to look for "flaky-est" envs.
-	const dateRange = 15
+	dateRange := f.window()
 
-	// Filters out data from prior to 90 days
-	// Then computes average number of fails for each environment for each time frame
-	// Then calculates the change in the average number of fails between the time frames
+	// Computes the average number of fails and the number of runs for each environment for each time frame from the daily runs, all of the data is recent if there are fewer dates
+	// Then calculates the change in the average number of fails between the time frames, leaving out the environments with fewer than $4 recent runs
 	sqlQuery = `
-	WITH data AS (
-		SELECT * 
-		FROM db_environment_tests 
-		WHERE TestTime >= NOW() - INTERVAL '90 days'
-	), dates AS (
-		SELECT DISTINCT DATE_TRUNC('day', TestTime) AS Date
-		FROM data
+	WITH` + envDaysData(f, 5) + `, dates AS (
+		SELECT DISTINCT Day AS Date
+		FROM env_days
 		ORDER BY Date DESC
 		LIMIT $1
 	), recentCutoff AS (
@@ -470,28 +1064,39 @@ func (m *Postgres) GetOverview() (map[string]interface{}, error) {
 		ORDER BY Date DESC
 		OFFSET $3
 		LIMIT 1
+	), cutoffs AS (
+		SELECT COALESCE((SELECT Date FROM recentCutoff), '-infinity') AS RecentCutoff,
+		COALESCE((SELECT Date FROM prevCutoff), '-infinity') AS PrevCutoff
 	), temp AS (
 	SELECT EnvName,
-	ROUND(COALESCE(AVG(CASE WHEN TestTime > (SELECT Date FROM recentCutoff) THEN NumberOfFail END), 0), 2) AS RecentNumberOfFail,
-	ROUND(COALESCE(AVG(CASE WHEN TestTime <= (SELECT Date FROM recentCutoff) AND TestTime > (SELECT Date FROM prevCutoff) THEN NumberOfFail END), 0), 2) AS PrevNumberOfFail
-	FROM data
+	ROUND(COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN NumberOfFail END) * 1.0 / NULLIF(SUM(CASE WHEN Day >= c.RecentCutoff THEN Runs END), 0), 0), 2) AS RecentNumberOfFail,
+	ROUND(COALESCE(SUM(CASE WHEN Day < c.RecentCutoff AND Day >= c.PrevCutoff THEN NumberOfFail END) * 1.0 / NULLIF(SUM(CASE WHEN Day < c.RecentCutoff AND Day >= c.PrevCutoff THEN Runs END), 0), 0), 2) AS PrevNumberOfFail,
+	COALESCE(SUM(CASE WHEN Day >= c.RecentCutoff THEN Runs END), 0) AS RecentRuns
+	FROM env_days, cutoffs c
 	GROUP BY EnvName
-	ORDER BY RecentNumberOfFail DESC
 	)
-	SELECT EnvName, RecentNumberOfFail, RecentNumberOfFail - PrevNumberOfFail AS Growth
+	SELECT EnvName, RecentNumberOfFail, RecentNumberOfFail - PrevNumberOfFail AS Growth, RecentRuns
 	FROM temp
+	WHERE RecentRuns >= $4
 	ORDER BY RecentNumberOfFail DESC;
 	`
 	var summaryTable []models.DBSummaryTable
-	err = m.db.Select(&summaryTable, sqlQuery, 2*dateRange, dateRange-1, 2*dateRange-1)
+	err = m.db.SelectContext(ctx, &summaryTable, sqlQuery, f.args(2*dateRange, dateRange-1, 2*dateRange-1, f.minRuns(), "")...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute SQL query for flake table: %v", err)
 	}
 	log.Printf("\nduration metric: took %f seconds to execute SQL query for summary failure change table since start of handler", time.Since(start).Seconds())
 
+	regressions, err := m.durationRegressions(ctx, f, "", DefaultRegressionRatio)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("\nduration metric: took %f seconds to execute SQL query for duration regressions since start of handler", time.Since(start).Seconds())
+
 	data := map[string]interface{}{
-		"summaryAvgFail": summaryAvgFail,
-		"summaryTable":   summaryTable,
+		"summaryAvgFail":      summaryAvgFail,
+		"summaryTable":        summaryTable,
+		"durationRegressions": regressions,
 	}
 	log.Printf("\nduration metric: took %f seconds to gather summary data since start of handler\n\n", time.Since(start).Seconds())
 	return data, nil
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pruneInterval is how often the server prunes the runs older than the retention
+const pruneInterval = 24 * time.Hour
+
+// ParseAge parses a duration that can also be given in days, for example 180d or 36h
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q, expected a positive duration like 180d or 36h", s)
+	}
+	return d, nil
+}
+
+// StartRetention prunes the runs older than retention every day in the background
+func StartRetention(d Datab, retention time.Duration) {
+	go func() {
+		for {
+			pruned, err := d.Prune(context.Background(), time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("failed to prune the runs older than %v: %v", retention, err)
+			} else if pruned != nil {
+				log.Printf("pruned %d runs and %d test cases older than %v", pruned.EnvironmentTests, pruned.TestCases, retention)
+			}
+			time.Sleep(pruneInterval)
+		}
+	}()
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"math"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+const (
+	// DefaultRegressionRatio is how many times slower than its baseline a test needs to get to be a duration regression
+	DefaultRegressionRatio = 1.5
+	// minRegressionSeconds is how many seconds slower a test needs to get to be a duration regression, so tests taking milliseconds are left out
+	minRegressionSeconds = 1
+)
+
+// testDuration is the duration of a passing test on a commit, in commit order
+type testDuration struct {
+	EnvName  string
+	TestName string
+	CommitID string
+	Duration float64
+}
+
+// regressionStart returns the start of the recent window of the duration regressions.
+// It starts at midnight, so the days before it can be read from the daily rollups.
+func regressionStart(f Filter) time.Time {
+	return f.to().AddDate(0, 0, -f.window()).UTC().Truncate(24 * time.Hour)
+}
+
+// setShiftCommits fills in the commit the durations of the regressions shifted at,
+// the durations are ordered by environment and test and then in commit order
+func setShiftCommits(regressions []models.DBDurationRegression, durations []testDuration) {
+	byTest := map[[2]string][]testDuration{}
+	for _, d := range durations {
+		key := [2]string{d.EnvName, d.TestName}
+		byTest[key] = append(byTest[key], d)
+	}
+	for i, r := range regressions {
+		regressions[i].FirstCommit = shiftCommit(byTest[[2]string{r.EnvName, r.TestName}])
+	}
+}
+
+// shiftCommit returns the commit the durations shifted at, the start of the split of the durations
+// into a before and an after part with the lowest sum of squared deviations from the mean of each part.
+// It returns "" if the durations did not go up at any split.
+func shiftCommit(durations []testDuration) string {
+	n := len(durations)
+	if n < 2 {
+		return ""
+	}
+	// prefix sums of the durations and their squares
+	sum := make([]float64, n+1)
+	sumSq := make([]float64, n+1)
+	for i, d := range durations {
+		sum[i+1] = sum[i] + d.Duration
+		sumSq[i+1] = sumSq[i] + d.Duration*d.Duration
+	}
+	sse := func(i, j int) float64 {
+		s := sum[j] - sum[i]
+		return sumSq[j] - sumSq[i] - s*s/float64(j-i)
+	}
+	best, bestCost := -1, math.Inf(1)
+	for k := 1; k < n; k++ {
+		// only a split where the durations went up is a regression
+		if (sum[n]-sum[k])/float64(n-k) <= sum[k]/float64(k) {
+			continue
+		}
+		if cost := sse(0, k) + sse(k, n); cost < bestCost {
+			best, bestCost = k, cost
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return durations[best].CommitID
+}
+
+// setRatios fills in the ratio of the recent to the baseline median of the regressions
+func setRatios(regressions []models.DBDurationRegression) {
+	for i, r := range regressions {
+		if r.BaselineMedian > 0 {
+			regressions[i].Ratio = float32(math.Round(float64(r.RecentMedian/r.BaselineMedian)*100) / 100)
+		}
+	}
+}
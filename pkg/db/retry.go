@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+const (
+	// DefaultRetries is the number of times a failed write is retried
+	DefaultRetries = 3
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// retry calls fn until it succeeds, at most retries+1 times, waiting twice as long after each failure.
+// The last error is returned, or the error of ctx if it is done while waiting.
+func retry(ctx context.Context, retries int, fn func(context.Context) error) error {
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= retries {
+			return err
+		}
+		// half of the backoff is random, so the CI jobs failing together do not retry together
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+		log.Printf("attempt %d of %d failed, retrying in %v: %v", attempt+1, retries+1, wait.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Write stores a run in the database configured by fv, retrying the failed connections and writes fv.Retries times.
+// The runs left in the spool by earlier failures are stored first. If the run still cannot be stored and spool is not nil,
+// it is added to the spool and the path of its file is returned with the error.
+func Write(ctx context.Context, fv FlagValues, spool *Spool, run models.DBEnvironmentTest, tests []models.DBTestCase) (string, error) {
+	// the database is opened once, the retries of the writes reuse its connection pool
+	var database Datab
+	err := retry(ctx, fv.Retries, func(ctx context.Context) error {
+		if database == nil {
+			d, err := FromEnv(fv)
+			if err != nil {
+				return err
+			}
+			database = d
+		}
+		if err := database.Initialize(ctx); err != nil {
+			return err
+		}
+		if spool != nil {
+			spool.Flush(ctx, database) //nolint:errcheck // the failures are logged, the spooled runs are retried by the next write
+		}
+		return database.Set(ctx, run, tests)
+	})
+	if err == nil || spool == nil {
+		return "", err
+	}
+	path, spoolErr := spool.Add(run, tests)
+	if spoolErr != nil {
+		return "", fmt.Errorf("%v, and %v", err, spoolErr)
+	}
+	return path, err
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidCursor is returned for a RowQuery with a cursor that was not returned with a page
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const (
+	// DefaultPageSize is the number of rows of a page when the query has no Limit
+	DefaultPageSize = 100
+	// MaxPageSize is the largest number of rows of a page
+	MaxPageSize = 1000
+)
+
+// RowQuery selects a page of the stored runs or test cases of a Filter, newest first
+type RowQuery struct {
+	Env    string
+	Test   string
+	Result string
+	Commit string
+	// Cursor is the position the page starts after, the NextCursor of the previous page. The first page if empty
+	Cursor string
+	// Limit is the number of rows of the page, DefaultPageSize if zero
+	Limit int
+}
+
+// limit returns the number of rows of the page
+func (q RowQuery) limit() int {
+	if q.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return q.Limit
+}
+
+// cursor is the position of a row in the newest first order of the pages
+type cursor struct {
+	TestTime time.Time `json:"t"`
+	CommitID string    `json:"c"`
+	EnvName  string    `json:"e"`
+	TestName string    `json:"n,omitempty"`
+}
+
+// encode returns the opaque form of the cursor given to the clients
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor returned by encode, the zero cursor and false if s is empty
+func decodeCursor(s string) (cursor, bool, error) {
+	var c cursor
+	if s == "" {
+		return c, false, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, false, fmt.Errorf("%w %q: %v", ErrInvalidCursor, s, err)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, false, fmt.Errorf("%w %q: %v", ErrInvalidCursor, s, err)
+	}
+	return c, true, nil
+}
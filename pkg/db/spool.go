@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// spoolExt is the extension of the files of the spooled runs
+const spoolExt = ".json"
+
+// Spool keeps the runs that could not be stored in a directory, one json file per run, until they are flushed to a database.
+// The runs are kept in a subdirectory per database, so they are only flushed to the database they were meant for
+type Spool struct {
+	dir string
+}
+
+// spooledRun is the content of a spool file
+type spooledRun struct {
+	Run       models.DBEnvironmentTest `json:"run"`
+	TestCases []models.DBTestCase      `json:"testCases"`
+}
+
+// NewSpool returns the spool of the runs of the database configured by fv in a directory, created when a run is added
+func NewSpool(dir string, fv FlagValues) *Spool {
+	return &Spool{dir: filepath.Join(dir, spoolTarget(fv))}
+}
+
+// spoolTarget returns the name of the spool subdirectory of the database configured by fv, a hash of its backend, host and path
+func spoolTarget(fv FlagValues) string {
+	orEnv := func(v, env string) string {
+		if v != "" {
+			return v
+		}
+		return os.Getenv(env)
+	}
+	target := fmt.Sprintf("%s\x00%s\x00%s\x00%t", orEnv(fv.Backend, "DB_BACKEND"), orEnv(fv.Host, "DB_HOST"), orEnv(fv.Path, "DB_PATH"), fv.UseCloudSQL)
+	sum := sha256.Sum256([]byte(target))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Add writes a run to the spool. A run spooled again replaces its previous file
+func (s *Spool) Add(run models.DBEnvironmentTest, tests []models.DBTestCase) (string, error) {
+	b, err := json.Marshal(spooledRun{Run: run, TestCases: tests})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the spooled run: %v", err)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create the spool directory: %v", err)
+	}
+	key := sha256.Sum256([]byte(run.Project + "\x00" + run.CommitID + "\x00" + run.EnvName))
+	path := filepath.Join(s.dir, hex.EncodeToString(key[:8])+spoolExt)
+	// written to a temporary file first so a flush never reads a partial run
+	tmp, err := os.CreateTemp(s.dir, ".spool-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to spool the run: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to spool the run: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to spool the run: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to spool the run: %v", err)
+	}
+	return path, nil
+}
+
+// Pending returns the files of the spooled runs
+func (s *Spool) Pending() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the spool directory: %v", err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), spoolExt) && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(s.dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Flush stores the spooled runs in d and removes them from the spool, returning the number of runs stored.
+// The runs that fail are logged and kept for the next flush, the error says how many failed
+func (s *Spool) Flush(ctx context.Context, d Datab) (int, error) {
+	files, err := s.Pending()
+	if err != nil {
+		return 0, err
+	}
+	flushed, failed := 0, 0
+	for _, path := range files {
+		if err := flushFile(ctx, d, path); err != nil {
+			log.Printf("failed to flush the spooled run %s: %v", path, err)
+			failed++
+			continue
+		}
+		flushed++
+	}
+	if failed > 0 {
+		return flushed, fmt.Errorf("failed to flush %d of %d spooled runs", failed, len(files))
+	}
+	return flushed, nil
+}
+
+// flushFile stores the run of a spool file and removes it
+func flushFile(ctx context.Context, d Datab, path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// flushed by a concurrent run
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var r spooledRun
+	if err := json.Unmarshal(b, &r); err != nil {
+		return fmt.Errorf("failed to parse: %v", err)
+	}
+	if err := d.Set(ctx, r.Run, r.TestCases); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
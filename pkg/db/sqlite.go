@@ -1,9 +1,12 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 
@@ -39,14 +42,118 @@ var createTestCasesTableSQL = `
 	);
 `
 
+// sqliteMigrations are the schema changes since the tables were first created
+var sqliteMigrations = []migration{
+	// project dimension, rows stored before it belong to the '' project.
+	// SQLite cannot change the primary key of a table, so the tables are rebuilt
+	{
+		`CREATE TABLE db_environment_tests_new (
+			Project TEXT NOT NULL DEFAULT '',
+			CommitID TEXT,
+			EnvName TEXT,
+			GopoghTime TEXT,
+			TestTime TEXT,
+			NumberOfFail INTEGER,
+			NumberOfPass INTEGER,
+			NumberOfSkip INTEGER,
+			TotalDuration REAL,
+			GopoghVersion TEXT,
+			PRIMARY KEY (Project, CommitID, EnvName)
+		)`,
+		`INSERT INTO db_environment_tests_new (CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, GopoghVersion)
+			SELECT CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, GopoghVersion FROM db_environment_tests`,
+		`DROP TABLE db_environment_tests`,
+		`ALTER TABLE db_environment_tests_new RENAME TO db_environment_tests`,
+		`CREATE TABLE db_test_cases_new (
+			Project TEXT NOT NULL DEFAULT '',
+			PR TEXT,
+			CommitId TEXT,
+			TestName TEXT,
+			Result TEXT,
+			Duration REAL,
+			EnvName TEXT,
+			TestOrder INTEGER,
+			TestTime TEXT,
+			PRIMARY KEY (Project, CommitId, EnvName, TestName)
+		)`,
+		`INSERT INTO db_test_cases_new (PR, CommitId, TestName, Result, Duration, EnvName, TestOrder, TestTime)
+			SELECT PR, CommitId, TestName, Result, Duration, EnvName, TestOrder, TestTime FROM db_test_cases`,
+		`DROP TABLE db_test_cases`,
+		`ALTER TABLE db_test_cases_new RENAME TO db_test_cases`,
+	},
+	// branch dimension, and the PR of the runs so the environment charts can leave out PR runs
+	{
+		`ALTER TABLE db_environment_tests ADD COLUMN Branch TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN PR TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_test_cases ADD COLUMN Branch TEXT NOT NULL DEFAULT ''`,
+		`UPDATE db_environment_tests SET PR = COALESCE((SELECT t.PR FROM db_test_cases t
+			WHERE t.Project = db_environment_tests.Project AND t.CommitId = db_environment_tests.CommitID AND t.EnvName = db_environment_tests.EnvName AND t.PR IS NOT NULL LIMIT 1), '')`,
+	},
+	// run metadata
+	{
+		`ALTER TABLE db_environment_tests ADD COLUMN GoVersion TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN GOOS TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN GOARCH TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN Hostname TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN Trigger TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN Labels TEXT NOT NULL DEFAULT '{}'`,
+	},
+	// free form details, CommitID used to hold the -details of the run so they are copied over
+	{
+		`ALTER TABLE db_environment_tests ADD COLUMN Details TEXT NOT NULL DEFAULT ''`,
+		`UPDATE db_environment_tests SET Details = CommitID`,
+	},
+	// commit history, for ordering the runs by commit instead of by time
+	{
+		`ALTER TABLE db_environment_tests ADD COLUMN ParentCommit TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE db_environment_tests ADD COLUMN CommitOrder INTEGER NOT NULL DEFAULT 0`,
+	},
+	// owners of the tests
+	{
+		`ALTER TABLE db_test_cases ADD COLUMN Owner TEXT NOT NULL DEFAULT ''`,
+	},
+	// daily rollups of the pruned rows
+	{
+		`CREATE TABLE IF NOT EXISTS db_test_cases_daily (
+			Project TEXT NOT NULL,
+			EnvName TEXT NOT NULL,
+			Branch TEXT NOT NULL,
+			PostMerge INTEGER NOT NULL,
+			TestName TEXT NOT NULL,
+			Day TEXT NOT NULL,
+			Runs INTEGER NOT NULL,
+			Fails INTEGER NOT NULL,
+			Skips INTEGER NOT NULL,
+			TotalDuration REAL NOT NULL,
+			PRIMARY KEY (Project, EnvName, Branch, PostMerge, TestName, Day)
+		)`,
+		`CREATE TABLE IF NOT EXISTS db_environment_tests_daily (
+			Project TEXT NOT NULL,
+			EnvName TEXT NOT NULL,
+			Branch TEXT NOT NULL,
+			PostMerge INTEGER NOT NULL,
+			Day TEXT NOT NULL,
+			Runs INTEGER NOT NULL,
+			NumberOfFail INTEGER NOT NULL,
+			NumberOfPass INTEGER NOT NULL,
+			NumberOfSkip INTEGER NOT NULL,
+			TotalDuration REAL NOT NULL,
+			PRIMARY KEY (Project, EnvName, Branch, PostMerge, Day)
+		)`,
+	},
+}
+
 type sqlite struct {
-	db   *sqlx.DB
-	path string
+	db      *sqlx.DB
+	path    string
+	timeout time.Duration
 }
 
 // Set adds/updates rows to the database
-func (m *sqlite) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
-	tx, err := m.db.DB.Begin()
+func (m *sqlite) Set(ctx context.Context, commitRow models.DBEnvironmentTest, dbRows []models.DBTestCase) error {
+	ctx, cancel := withTimeout(ctx, m.timeout)
+	defer cancel()
+	tx, err := m.db.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create SQL transaction: %v", err)
 	}
@@ -58,22 +165,19 @@ func (m *sqlite) Set(commitRow models.DBEnvironmentTest, dbRows []models.DBTestC
 		}
 	}()
 
-	sqlInsert := `INSERT OR REPLACE INTO db_test_cases (PR, CommitId, TestName, Result, Duration, EnvName, TestOrder, TestTime) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
-	stmt, err := tx.Prepare(sqlInsert)
-	if err != nil {
-		return fmt.Errorf("failed to prepare SQL insert statement: %v", err)
+	insertTestCases := func(rows int) string {
+		return `INSERT OR REPLACE INTO db_test_cases (Project, PR, CommitId, TestName, Result, Duration, EnvName, TestOrder, TestTime, Branch, Owner) VALUES ` + valuesList(rows, 11, false)
 	}
-	defer stmt.Close()
-
-	for _, r := range dbRows {
-		_, err := stmt.Exec(r.PR, r.CommitID, r.TestName, r.Result, r.Duration, r.EnvName, r.TestOrder, r.TestTime.String())
-		if err != nil {
-			return fmt.Errorf("failed to execute SQL insert: %v", err)
-		}
+	err = insertBatches(ctx, tx, len(dbRows), sqliteInsertBatchSize, insertTestCases, func(i int) []interface{} {
+		r := dbRows[i]
+		return []interface{}{r.Project, r.PR, r.CommitID, r.TestName, r.Result, r.Duration, r.EnvName, r.TestOrder, r.TestTime.String(), r.Branch, r.Owner}
+	})
+	if err != nil {
+		return err
 	}
 
-	sqlInsert = `INSERT OR REPLACE INTO db_environment_tests (CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, GopoghVersion) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err = tx.Exec(sqlInsert, commitRow.CommitID, commitRow.EnvName, commitRow.GopoghTime, commitRow.TestTime.String(), commitRow.NumberOfFail, commitRow.NumberOfPass, commitRow.NumberOfSkip, commitRow.TotalDuration, commitRow.GopoghVersion)
+	sqlInsert := `INSERT OR REPLACE INTO db_environment_tests (Project, CommitID, EnvName, GopoghTime, TestTime, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration, GopoghVersion, Branch, PR, GoVersion, GOOS, GOARCH, Hostname, Trigger, Labels, Details, ParentCommit, CommitOrder) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, sqlInsert, commitRow.Project, commitRow.CommitID, commitRow.EnvName, commitRow.GopoghTime, commitRow.TestTime.String(), commitRow.NumberOfFail, commitRow.NumberOfPass, commitRow.NumberOfSkip, commitRow.TotalDuration, commitRow.GopoghVersion, commitRow.Branch, commitRow.PR, commitRow.GoVersion, commitRow.GOOS, commitRow.GOARCH, commitRow.Hostname, commitRow.Trigger, commitRow.Labels, commitRow.Details, commitRow.ParentCommit, commitRow.CommitOrder)
 	if err != nil {
 		return fmt.Errorf("failed to execute SQL insert: %v", err)
 	}
@@ -94,45 +198,186 @@ func newSQLite(cfg config) (*sqlite, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %v", err)
 	}
+	cfg.configurePool(database)
 	m := &sqlite{
-		db:   database,
-		path: cfg.path,
+		db:      database,
+		path:    cfg.path,
+		timeout: cfg.timeout,
 	}
 	return m, nil
 }
 
 // Initialize creates the tables within the SQLite database
-func (m *sqlite) Initialize() error {
+func (m *sqlite) Initialize(ctx context.Context) error {
 
-	if _, err := m.db.Exec(createEnvironmentTestsTableSQL); err != nil {
+	if _, err := m.db.ExecContext(ctx, createEnvironmentTestsTableSQL); err != nil {
 		return fmt.Errorf("failed to initialize environment tests table: %v", err)
 	}
-	if _, err := m.db.Exec(createTestCasesTableSQL); err != nil {
+	if _, err := m.db.ExecContext(ctx, createTestCasesTableSQL); err != nil {
 		return fmt.Errorf("failed to initialize test cases table: %v", err)
 	}
-	return nil
+	return migrate(ctx, m.db, sqliteMigrations, "")
 }
 
 // GetEnvironmentTestsAndTestCases writes the database tables to a map with the keys environmentTests and testCases
 // This is not yet supported for sqlite
-func (m *sqlite) GetEnvironmentTestsAndTestCases() (map[string]interface{}, error) {
+func (m *sqlite) GetEnvironmentTestsAndTestCases(_ context.Context, _ string) (map[string]interface{}, error) {
 	return nil, nil
 }
 
 // GetEnvCharts writes the overall environment charts to a map with the keys recentFlakePercentTable, flakeRateByWeek, flakeRateByDay, and countsAndDurations
 // This is not yet supported for sqlite
-func (m *sqlite) GetEnvCharts(_ string, _ int) (map[string]interface{}, error) {
+func (m *sqlite) GetEnvCharts(_ context.Context, _ Filter, _ string, _ int) (map[string]interface{}, error) {
 	return nil, nil
 }
 
 // GetTestCharts writes the individual test chart data to a map with the keys flakeByDay and flakeByWeek
 // This is not yet supported for sqlite
-func (m *sqlite) GetTestCharts(_ string, _ string) (map[string]interface{}, error) {
+func (m *sqlite) GetTestCharts(_ context.Context, _ Filter, _, _ string) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// GetTestHistory writes the results of the test on the last n commits to a map with the keys history and firstFailure
+// This is not yet supported for sqlite
+func (m *sqlite) GetTestHistory(_ context.Context, _ Filter, _, _ string, _ int) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// GetDurationRegressions writes the tests that got slower to a map with the key durationRegressions
+// This is not yet supported for sqlite
+func (m *sqlite) GetDurationRegressions(_ context.Context, _ Filter, _ string, _ float64) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// GetRunStats returns the stats of the post-merge runs of the environment and branch of run between since and run
+// This is not yet supported for sqlite
+func (m *sqlite) GetRunStats(_ context.Context, _ models.DBEnvironmentTest, _ time.Time) (*models.DBRunStats, error) {
+	return nil, nil
+}
+
+// GetTestFailures returns the number of runs and failures of every test on every environment
+// This is not yet supported for sqlite
+func (m *sqlite) GetTestFailures(_ context.Context, _ Filter) ([]models.DBTestFailures, error) {
+	return nil, nil
+}
+
+// GetOwners writes the flake rates and failures of the tests per owner to a map with the keys owners and ownerTests
+// This is not yet supported for sqlite
+func (m *sqlite) GetOwners(_ context.Context, _ Filter) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// GetDailyTrend writes the daily runs, flake rate and duration of a test on an environment, or of the environment if test is empty,
+// to a map with the key dailyTrend
+// This is not yet supported for sqlite
+func (m *sqlite) GetDailyTrend(_ context.Context, _ Filter, _, _ string) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+// sqliteTimeLayout is the layout of the times stored by Set, the one of time.Time.String
+const sqliteTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// parseSQLiteTime parses a time stored by Set, dropping the monotonic clock reading time.Time.String may add
+func parseSQLiteTime(s string) (time.Time, error) {
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+	return time.Parse(sqliteTimeLayout, s)
+}
+
+// sqliteRollupTestCasesSQL adds the test cases of a run (project, commit and environment) to the daily rollups of a day
+const sqliteRollupTestCasesSQL = `
+	INSERT INTO db_test_cases_daily (Project, EnvName, Branch, PostMerge, TestName, Day, Runs, Fails, Skips, TotalDuration)
+	SELECT Project, EnvName, Branch, COALESCE(PR, '') = '', TestName, ?,
+	COUNT(CASE WHEN Result != 'skip' THEN 1 END), COUNT(CASE WHEN Result = 'fail' THEN 1 END), COUNT(CASE WHEN Result = 'skip' THEN 1 END),
+	COALESCE(SUM(CASE WHEN Result != 'skip' THEN Duration END), 0)
+	FROM db_test_cases
+	WHERE Project = ? AND CommitId = ? AND EnvName = ?
+	GROUP BY 1, 2, 3, 4, 5
+	ON CONFLICT (Project, EnvName, Branch, PostMerge, TestName, Day)
+	DO UPDATE SET Runs = Runs + excluded.Runs, Fails = Fails + excluded.Fails, Skips = Skips + excluded.Skips, TotalDuration = TotalDuration + excluded.TotalDuration
+`
+
+// sqliteRollupEnvironmentTestsSQL adds a run (project, commit and environment) to the daily rollups of a day
+const sqliteRollupEnvironmentTestsSQL = `
+	INSERT INTO db_environment_tests_daily (Project, EnvName, Branch, PostMerge, Day, Runs, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration)
+	SELECT Project, EnvName, Branch, PR = '', ?, 1, NumberOfFail, NumberOfPass, NumberOfSkip, TotalDuration
+	FROM db_environment_tests
+	WHERE Project = ? AND CommitID = ? AND EnvName = ?
+	ON CONFLICT (Project, EnvName, Branch, PostMerge, Day)
+	DO UPDATE SET Runs = Runs + excluded.Runs, NumberOfFail = NumberOfFail + excluded.NumberOfFail, NumberOfPass = NumberOfPass + excluded.NumberOfPass,
+		NumberOfSkip = NumberOfSkip + excluded.NumberOfSkip, TotalDuration = TotalDuration + excluded.TotalDuration
+`
+
+// Prune rolls the rows of the runs before the given time up into the daily tables and deletes them.
+// The test times are stored as text with their time zone, so they are compared once parsed rather than in SQL,
+// and the test cases are rolled up on the day of their run.
+func (m *sqlite) Prune(ctx context.Context, before time.Time) (*models.DBPruneResult, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SQL transaction: %v", err)
+	}
+	defer tx.Rollback() //nolint:errcheck // rollback after commit is a no-op
+
+	var runs []struct {
+		Project  string `db:"Project"`
+		CommitID string `db:"CommitID"`
+		EnvName  string `db:"EnvName"`
+		TestTime string `db:"TestTime"`
+	}
+	if err := tx.SelectContext(ctx, &runs, `SELECT Project, CommitID, EnvName, TestTime FROM db_environment_tests`); err != nil {
+		return nil, fmt.Errorf("failed to list environment tests: %v", err)
+	}
+	var pruned models.DBPruneResult
+	for _, r := range runs {
+		t, err := parseSQLiteTime(r.TestTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the test time of %s on %s: %v", r.CommitID, r.EnvName, err)
+		}
+		if !t.Before(before) {
+			continue
+		}
+		day := t.UTC().Format("2006-01-02")
+		if _, err := tx.ExecContext(ctx, sqliteRollupTestCasesSQL, day, r.Project, r.CommitID, r.EnvName); err != nil {
+			return nil, fmt.Errorf("failed to roll up test cases: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, sqliteRollupEnvironmentTestsSQL, day, r.Project, r.CommitID, r.EnvName); err != nil {
+			return nil, fmt.Errorf("failed to roll up environment tests: %v", err)
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM db_test_cases WHERE Project = ? AND CommitId = ? AND EnvName = ?`, r.Project, r.CommitID, r.EnvName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete test cases: %v", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to count deleted test cases: %v", err)
+		}
+		pruned.TestCases += n
+		if _, err := tx.ExecContext(ctx, `DELETE FROM db_environment_tests WHERE Project = ? AND CommitID = ? AND EnvName = ?`, r.Project, r.CommitID, r.EnvName); err != nil {
+			return nil, fmt.Errorf("failed to delete environment tests: %v", err)
+		}
+		pruned.EnvironmentTests++
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit prune transaction: %v", err)
+	}
+	return &pruned, nil
+}
+
+// GetRuns returns a page of the stored runs
+// This is not yet supported for sqlite
+func (m *sqlite) GetRuns(_ context.Context, _ Filter, _ RowQuery) (*models.DBRunsPage, error) {
+	return nil, nil
+}
+
+// GetTestCases returns a page of the stored test cases
+// This is not yet supported for sqlite
+func (m *sqlite) GetTestCases(_ context.Context, _ Filter, _ RowQuery) (*models.DBTestCasesPage, error) {
 	return nil, nil
 }
 
 // GetOverview writes the overview charts to a map with the keys summaryAvgFail and summaryTable
 // This is not yet supported for sqlite
-func (m *sqlite) GetOverview() (map[string]interface{}, error) {
+func (m *sqlite) GetOverview(_ context.Context, _ Filter) (map[string]interface{}, error) {
 	return nil, nil
 }
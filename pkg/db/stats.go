@@ -0,0 +1,22 @@
+package db
+
+import "math"
+
+// z95 is the z-score of a 95% confidence interval
+const z95 = 1.96
+
+// wilsonInterval returns the bounds of the 95% Wilson score interval of the failure percentage of fails out of runs.
+// Unlike the failure percentage alone it accounts for the number of runs, 1 failure out of 1 run is between 20.65% and 100%.
+func wilsonInterval(fails, runs int) (low, high float32) {
+	if runs == 0 {
+		return 0, 100
+	}
+	n := float64(runs)
+	p := float64(fails) / n
+	denominator := 1 + z95*z95/n
+	center := p + z95*z95/(2*n)
+	margin := z95 * math.Sqrt(p*(1-p)/n+z95*z95/(4*n*n))
+	low = float32(math.Round(math.Max(0, (center-margin)/denominator)*10000) / 100)
+	high = float32(math.Round(math.Min(1, (center+margin)/denominator)*10000) / 100)
+	return low, high
+}
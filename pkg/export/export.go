@@ -0,0 +1,219 @@
+// Package export streams the stored runs and test cases to CSV or Parquet
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+const (
+	// CSV is the format of comma separated files with a header row
+	CSV = "csv"
+	// Parquet is the format of Apache Parquet files
+	Parquet = "parquet"
+)
+
+// parquetParallelism is the number of goroutines encoding the parquet columns
+const parquetParallelism = 4
+
+// ErrNotSupported is returned when the database backend cannot list its rows
+var ErrNotSupported = errors.New("export is not supported by the database backend")
+
+// RunColumns are the CSV columns of the runs, see RunRecord
+var RunColumns = []string{"Project", "Branch", "PR", "CommitID", "Details", "ParentCommit", "CommitOrder", "EnvName", "GopoghTime", "TestTime",
+	"NumberOfFail", "NumberOfPass", "NumberOfSkip", "TotalDuration", "GopoghVersion", "GoVersion", "GOOS", "GOARCH", "Hostname", "Trigger", "Labels"}
+
+// TestCaseColumns are the CSV columns of the test cases, see TestCaseRecord
+var TestCaseColumns = []string{"Project", "Branch", "PR", "CommitID", "EnvName", "TestName", "Owner", "Result", "Duration", "TestTime"}
+
+// Runs writes the runs of the filter matching the query to w in the format, returning the number of rows written.
+// The rows are read a page at a time so the export does not need to fit in memory, the limit of the query is ignored.
+// The query timeout of the database applies to each page.
+func Runs(ctx context.Context, w io.Writer, d db.Datab, f db.Filter, q db.RowQuery, format string) (int, error) {
+	var enc encoder[models.DBEnvironmentTest]
+	switch format {
+	case CSV:
+		enc = newCSVEncoder(w, RunColumns, RunRecord)
+	case Parquet:
+		enc = newParquetEncoder(w, runRow)
+	default:
+		return 0, fmt.Errorf("unknown export format %q, expected csv or parquet", format)
+	}
+	return export(enc, q, func(q db.RowQuery) ([]models.DBEnvironmentTest, string, error) {
+		page, err := d.GetRuns(ctx, f, q)
+		if err != nil || page == nil {
+			return nil, "", notSupported(page == nil, err)
+		}
+		return page.Runs, page.NextCursor, nil
+	})
+}
+
+// TestCases writes the test cases of the filter matching the query to w in the format, returning the number of rows written.
+// The rows are read a page at a time so the export does not need to fit in memory, the limit of the query is ignored.
+func TestCases(ctx context.Context, w io.Writer, d db.Datab, f db.Filter, q db.RowQuery, format string) (int, error) {
+	var enc encoder[models.DBTestCase]
+	switch format {
+	case CSV:
+		enc = newCSVEncoder(w, TestCaseColumns, TestCaseRecord)
+	case Parquet:
+		enc = newParquetEncoder(w, testCaseRow)
+	default:
+		return 0, fmt.Errorf("unknown export format %q, expected csv or parquet", format)
+	}
+	return export(enc, q, func(q db.RowQuery) ([]models.DBTestCase, string, error) {
+		page, err := d.GetTestCases(ctx, f, q)
+		if err != nil || page == nil {
+			return nil, "", notSupported(page == nil, err)
+		}
+		return page.TestCases, page.NextCursor, nil
+	})
+}
+
+// notSupported returns err, or ErrNotSupported if the backend returned no page
+func notSupported(noPage bool, err error) error {
+	if err == nil && noPage {
+		return ErrNotSupported
+	}
+	return err
+}
+
+// export writes the pages returned by next to enc until the last page
+func export[T any](enc encoder[T], q db.RowQuery, next func(db.RowQuery) ([]T, string, error)) (int, error) {
+	q.Limit = db.MaxPageSize
+	written := 0
+	for {
+		rows, cursor, err := next(q)
+		if err != nil {
+			return written, err
+		}
+		if err := enc.write(rows); err != nil {
+			return written, fmt.Errorf("failed to write rows: %v", err)
+		}
+		written += len(rows)
+		if cursor == "" {
+			break
+		}
+		q.Cursor = cursor
+	}
+	if err := enc.close(); err != nil {
+		return written, fmt.Errorf("failed to finish export: %v", err)
+	}
+	return written, nil
+}
+
+// encoder writes rows in an export format
+type encoder[T any] interface {
+	write(rows []T) error
+	close() error
+}
+
+// csvEncoder writes rows as CSV records
+type csvEncoder[T any] struct {
+	w      *csv.Writer
+	record func(T) []string
+}
+
+func newCSVEncoder[T any](w io.Writer, columns []string, record func(T) []string) *csvEncoder[T] {
+	cw := csv.NewWriter(w)
+	_ = cw.Write(columns)
+	return &csvEncoder[T]{w: cw, record: record}
+}
+
+func (e *csvEncoder[T]) write(rows []T) error {
+	for _, r := range rows {
+		if err := e.w.Write(e.record(r)); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder[T]) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// parquetEncoder writes rows converted to R to a parquet file
+type parquetEncoder[T, R any] struct {
+	out io.Writer
+	w   *writer.ParquetWriter
+	row func(T) R
+}
+
+func newParquetEncoder[T, R any](w io.Writer, row func(T) R) *parquetEncoder[T, R] {
+	return &parquetEncoder[T, R]{out: w, row: row}
+}
+
+// writer returns the parquet writer, creating it on first use as it starts writing the file right away
+func (e *parquetEncoder[T, R]) writer() (*writer.ParquetWriter, error) {
+	if e.w != nil {
+		return e.w, nil
+	}
+	pw, err := writer.NewParquetWriterFromWriter(e.out, new(R), parquetParallelism)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	e.w = pw
+	return pw, nil
+}
+
+func (e *parquetEncoder[T, R]) write(rows []T) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	pw, err := e.writer()
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := pw.Write(e.row(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *parquetEncoder[T, R]) close() error {
+	pw, err := e.writer()
+	if err != nil {
+		return err
+	}
+	return pw.WriteStop()
+}
+
+// RunRecord returns the CSV record of a run, see RunColumns
+func RunRecord(r models.DBEnvironmentTest) []string {
+	return []string{r.Project, r.Branch, r.PR, r.CommitID, r.Details, r.ParentCommit, strconv.FormatInt(r.CommitOrder, 10), r.EnvName,
+		r.GopoghTime.Format(time.RFC3339Nano), r.TestTime.Format(time.RFC3339Nano), strconv.Itoa(r.NumberOfFail), strconv.Itoa(r.NumberOfPass),
+		strconv.Itoa(r.NumberOfSkip), strconv.FormatFloat(r.TotalDuration, 'f', -1, 64), r.GopoghVersion, r.GoVersion, r.GOOS, r.GOARCH,
+		r.Hostname, r.Trigger, labelsJSON(r.Labels)}
+}
+
+// TestCaseRecord returns the CSV record of a test case, see TestCaseColumns
+func TestCaseRecord(t models.DBTestCase) []string {
+	return []string{t.Project, t.Branch, t.PR, t.CommitID, t.EnvName, t.TestName, t.Owner, t.Result,
+		strconv.FormatFloat(t.Duration, 'f', -1, 64), t.TestTime.Format(time.RFC3339Nano)}
+}
+
+// labelsJSON returns the labels as a json object
+func labelsJSON(l models.Labels) string {
+	if len(l) == 0 {
+		return "{}"
+	}
+	b, _ := json.Marshal(l)
+	return string(b)
+}
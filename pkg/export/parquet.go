@@ -0,0 +1,86 @@
+package export
+
+import (
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// parquetRun is the parquet schema of the runs
+type parquetRun struct {
+	Project       string  `parquet:"name=project, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Branch        string  `parquet:"name=branch, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PR            string  `parquet:"name=pr, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CommitID      string  `parquet:"name=commit_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Details       string  `parquet:"name=details, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ParentCommit  string  `parquet:"name=parent_commit, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CommitOrder   int64   `parquet:"name=commit_order, type=INT64"`
+	EnvName       string  `parquet:"name=env_name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	GopoghTime    int64   `parquet:"name=gopogh_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	TestTime      int64   `parquet:"name=test_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	NumberOfFail  int64   `parquet:"name=number_of_fail, type=INT64"`
+	NumberOfPass  int64   `parquet:"name=number_of_pass, type=INT64"`
+	NumberOfSkip  int64   `parquet:"name=number_of_skip, type=INT64"`
+	TotalDuration float64 `parquet:"name=total_duration, type=DOUBLE"`
+	GopoghVersion string  `parquet:"name=gopogh_version, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	GoVersion     string  `parquet:"name=go_version, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	GOOS          string  `parquet:"name=goos, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	GOARCH        string  `parquet:"name=goarch, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Hostname      string  `parquet:"name=hostname, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Trigger       string  `parquet:"name=trigger, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	// Labels is the json object of the labels
+	Labels string `parquet:"name=labels, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// parquetTestCase is the parquet schema of the test cases
+type parquetTestCase struct {
+	Project  string  `parquet:"name=project, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Branch   string  `parquet:"name=branch, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PR       string  `parquet:"name=pr, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CommitID string  `parquet:"name=commit_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EnvName  string  `parquet:"name=env_name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	TestName string  `parquet:"name=test_name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Owner    string  `parquet:"name=owner, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Result   string  `parquet:"name=result, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Duration float64 `parquet:"name=duration, type=DOUBLE"`
+	TestTime int64   `parquet:"name=test_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+}
+
+func runRow(r models.DBEnvironmentTest) parquetRun {
+	return parquetRun{
+		Project:       r.Project,
+		Branch:        r.Branch,
+		PR:            r.PR,
+		CommitID:      r.CommitID,
+		Details:       r.Details,
+		ParentCommit:  r.ParentCommit,
+		CommitOrder:   r.CommitOrder,
+		EnvName:       r.EnvName,
+		GopoghTime:    r.GopoghTime.UnixMilli(),
+		TestTime:      r.TestTime.UnixMilli(),
+		NumberOfFail:  int64(r.NumberOfFail),
+		NumberOfPass:  int64(r.NumberOfPass),
+		NumberOfSkip:  int64(r.NumberOfSkip),
+		TotalDuration: r.TotalDuration,
+		GopoghVersion: r.GopoghVersion,
+		GoVersion:     r.GoVersion,
+		GOOS:          r.GOOS,
+		GOARCH:        r.GOARCH,
+		Hostname:      r.Hostname,
+		Trigger:       r.Trigger,
+		Labels:        labelsJSON(r.Labels),
+	}
+}
+
+func testCaseRow(t models.DBTestCase) parquetTestCase {
+	return parquetTestCase{
+		Project:  t.Project,
+		Branch:   t.Branch,
+		PR:       t.PR,
+		CommitID: t.CommitID,
+		EnvName:  t.EnvName,
+		TestName: t.TestName,
+		Owner:    t.Owner,
+		Result:   t.Result,
+		Duration: t.Duration,
+		TestTime: t.TestTime.UnixMilli(),
+	}
+}
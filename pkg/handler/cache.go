@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries is the number of responses kept by the cache, it is emptied when it is full
+const maxCacheEntries = 1000
+
+// Cache keeps the responses of the dashboard endpoints until new data is ingested or they are older than the TTL.
+// The TTL covers the data not ingested through the server, for example uploaded by gopogh straight to the database.
+type Cache struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	generation uint64
+	entries    map[string]*cacheEntry
+}
+
+// cacheEntry is a cached response
+type cacheEntry struct {
+	header  http.Header
+	body    []byte
+	etag    string
+	created time.Time
+}
+
+// NewCache returns a cache keeping the responses for ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		entries: map[string]*cacheEntry{},
+	}
+}
+
+// Invalidate drops all of the cached responses
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = map[string]*cacheEntry{}
+}
+
+// get returns the cached response of key and the current generation of the cache
+func (c *Cache) get(key string) (*cacheEntry, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && time.Since(e.created) > c.ttl {
+		delete(c.entries, key)
+		return nil, c.generation
+	}
+	return e, c.generation
+}
+
+// put caches the response of key unless the cache was invalidated since generation
+func (c *Cache) put(key string, generation uint64, header http.Header, body []byte) *cacheEntry {
+	sum := sha256.Sum256(body)
+	e := &cacheEntry{
+		header:  header,
+		body:    body,
+		etag:    `"` + hex.EncodeToString(sum[:16]) + `"`,
+		created: time.Now(),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return e
+	}
+	if len(c.entries) >= maxCacheEntries {
+		c.entries = map[string]*cacheEntry{}
+	}
+	c.entries[key] = e
+	return e
+}
+
+// Cached caches the successful responses of h per path and query parameters, and answers If-None-Match requests
+// with 304 Not Modified. It returns h if caching is disabled.
+func (m *DB) Cached(h http.HandlerFunc) http.HandlerFunc {
+	if m.Cache == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h(w, r)
+			return
+		}
+		// Encode sorts the query parameters, so their order does not matter
+		key := r.URL.Path + "?" + r.URL.Query().Encode()
+		e, generation := m.Cache.get(key)
+		if e == nil {
+			rec := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+			h(rec, r)
+			if rec.status != http.StatusOK {
+				rec.writeTo(w)
+				return
+			}
+			e = m.Cache.put(key, generation, rec.header, rec.body.Bytes())
+		}
+		e.writeTo(w, r)
+	}
+}
+
+// writeTo writes the cached response, or 304 Not Modified if the client has it
+func (e *cacheEntry) writeTo(w http.ResponseWriter, r *http.Request) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("ETag", e.etag)
+	// the browser has to revalidate its copy, as it changes when new data is ingested
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == e.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	_, _ = w.Write(e.body)
+}
+
+// responseRecorder records the response of a handler to cache it
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+// writeTo writes the recorded response as is
+func (rec *responseRecorder) writeTo(w http.ResponseWriter) {
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.status)
+	_, _ = w.Write(rec.body.Bytes())
+}
@@ -6,44 +6,76 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/medyagh/gopogh/pkg/alert"
 	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/owners"
 	"github.com/medyagh/gopogh/pkg/report"
+	"github.com/medyagh/gopogh/pkg/store"
 )
 
 type DB struct {
 	Database db.Datab
+	// DefaultProject is the project of requests without a project query parameter
+	DefaultProject string
+	// Tokens maps the ingest tokens to the project they are allowed to upload to
+	Tokens map[string]string
+	// Reports stores the uploaded reports, nil if report storage is disabled
+	Reports store.Store
+	// ReportFallback is the url template with {env} and {commit} placeholders of reports not found in Reports
+	ReportFallback string
+	// Alerts evaluates the alert rules after each ingestion, nil if alerting is disabled
+	Alerts *alert.Evaluator
+	// Owners sets the owners of the tests of the ingested reports, nil to keep the owners set by the uploader
+	Owners *owners.Owners
+	// Cache keeps the responses of the dashboard endpoints, nil if caching is disabled. See Cached
+	Cache *Cache
 }
 
 //go:embed flake_chart.html
 var flakeChartHTML string
 
-func (m *DB) ServeEnvironmentTestsAndTestCases(w http.ResponseWriter, _ *http.Request) {
-	data, err := m.Database.GetEnvironmentTestsAndTestCases()
+func (m *DB) ServeEnvironmentTestsAndTestCases(w http.ResponseWriter, r *http.Request) {
+	data, err := m.Database.GetEnvironmentTestsAndTestCases(r.Context(), m.project(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if data == nil {
-		http.Error(w, err.Error(), http.StatusNotImplemented)
+	writeJSON(w, versioned(r, data))
+}
+
+// ServeTestCharts writes the individual test charts to a JSON HTTP response
+func (m *DB) ServeTestCharts(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	env := queryValues.Get("env")
+	if env == "" {
+		http.Error(w, "missing environment name", http.StatusUnprocessableEntity)
 		return
 	}
-	jsonData, err := json.Marshal(data)
+	test := queryValues.Get("test")
+	if test == "" {
+		http.Error(w, "missing test name", http.StatusUnprocessableEntity)
+		return
+	}
+
+	f, err := m.filter(r)
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	_, err = w.Write(jsonData)
+	data, err := m.Database.GetTestCharts(r.Context(), f, env, test)
 	if err != nil {
-		http.Error(w, "Failed to write JSON data", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, versioned(r, data))
 }
 
-// ServeTestCharts writes the individual test charts to a JSON HTTP response
-func (m *DB) ServeTestCharts(w http.ResponseWriter, r *http.Request) {
+// ServeTestHistory writes the results of a test on the last commits to a JSON HTTP response
+func (m *DB) ServeTestHistory(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	env := queryValues.Get("env")
 	if env == "" {
@@ -55,28 +87,27 @@ func (m *DB) ServeTestCharts(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing test name", http.StatusUnprocessableEntity)
 		return
 	}
-
-	data, err := m.Database.GetTestCharts(env, test)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	commitsStr := queryValues.Get("commits")
+	if commitsStr == "" {
+		commitsStr = "50"
 	}
-	if data == nil {
-		http.Error(w, err.Error(), http.StatusNotImplemented)
+	commits, err := strconv.Atoi(commitsStr)
+	if err != nil || commits <= 0 {
+		http.Error(w, fmt.Sprintf("invalid number of commits: %q", commitsStr), http.StatusUnprocessableEntity)
 		return
 	}
-	jsonData, err := json.Marshal(data)
+
+	f, err := m.filter(r)
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	_, err = w.Write(jsonData)
+	data, err := m.Database.GetTestHistory(r.Context(), f, env, test, commits)
 	if err != nil {
-		http.Error(w, "Failed to write JSON data", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, versioned(r, data))
 }
 
 // ServeEnvCharts writes the overall environment charts to a JSON HTTP response
@@ -96,52 +127,93 @@ func (m *DB) ServeEnvCharts(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("invalid number of top tests to use: %v", err), http.StatusUnprocessableEntity)
 		return
 	}
-	data, err := m.Database.GetEnvCharts(env, testsInTop)
+	f, err := m.filter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	data, err := m.Database.GetEnvCharts(r.Context(), f, env, testsInTop)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if data == nil {
-		http.Error(w, err.Error(), http.StatusNotImplemented)
+	writeJSON(w, versioned(r, data))
+}
+
+// ServeOverview writes the overview chart for all of the environments to a JSON HTTP response
+func (m *DB) ServeOverview(w http.ResponseWriter, r *http.Request) {
+	f, err := m.filter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
-	jsonData, err := json.Marshal(data)
+	data, err := m.Database.GetOverview(r.Context(), f)
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	_, err = w.Write(jsonData)
+	writeJSON(w, versioned(r, data))
+}
+
+// ServeDurationRegressions writes the tests that got slower on an environment, or on every environment if env is not given, to a JSON HTTP response
+func (m *DB) ServeDurationRegressions(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	ratio := db.DefaultRegressionRatio
+	if s := queryValues.Get("ratio"); s != "" {
+		var err error
+		ratio, err = strconv.ParseFloat(s, 64)
+		if err != nil || ratio <= 1 {
+			http.Error(w, fmt.Sprintf("invalid ratio, it needs to be greater than 1: %q", s), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	f, err := m.filter(r)
 	if err != nil {
-		http.Error(w, "Failed to write JSON data", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	data, err := m.Database.GetDurationRegressions(r.Context(), f, queryValues.Get("env"), ratio)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, versioned(r, data))
 }
 
-// ServeOverview writes the overview chart for all of the environments to a JSON HTTP response
-func (m *DB) ServeOverview(w http.ResponseWriter, _ *http.Request) {
-	data, err := m.Database.GetOverview()
+// ServeOwners writes the flake rates and failures of the tests of each owner to a JSON HTTP response
+func (m *DB) ServeOwners(w http.ResponseWriter, r *http.Request) {
+	f, err := m.filter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	data, err := m.Database.GetOwners(r.Context(), f)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if data == nil {
-		http.Error(w, err.Error(), http.StatusNotImplemented)
+	writeJSON(w, versioned(r, data))
+}
+
+// ServeDailyTrend writes the daily trend of a test on an environment, or of the environment if test is not given, to a JSON HTTP response
+func (m *DB) ServeDailyTrend(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	env := queryValues.Get("env")
+	if env == "" {
+		http.Error(w, "missing environment name", http.StatusUnprocessableEntity)
 		return
 	}
-	jsonData, err := json.Marshal(data)
+	f, err := m.filter(r)
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	_, err = w.Write(jsonData)
+	data, err := m.Database.GetDailyTrend(r.Context(), f, env, queryValues.Get("test"))
 	if err != nil {
-		http.Error(w, "Failed to write JSON data", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, versioned(r, data))
 }
 
 // ServeGopoghVersion writes the gopogh version to a json response
@@ -149,6 +221,96 @@ func ServeGopoghVersion(w http.ResponseWriter, _ *http.Request) {
 	data := map[string]interface{}{
 		"version": report.Version,
 	}
+	writeJSON(w, data)
+}
+
+func ServeHTML(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	fmt.Fprint(w, flakeChartHTML)
+}
+
+// project returns the project query parameter of the request, or the default project
+func (m *DB) project(r *http.Request) string {
+	if p := r.URL.Query().Get("project"); p != "" {
+		return p
+	}
+	return m.DefaultProject
+}
+
+// filter returns the runs selected by the project, branch, pr, runs, label, from and to query parameters,
+// and the window and min_runs the rates are computed with.
+// Only post-merge runs are selected unless a pr or runs=all is given.
+func (m *DB) filter(r *http.Request) (db.Filter, error) {
+	q := r.URL.Query()
+	f := db.Filter{
+		Project: m.project(r),
+		Branch:  q.Get("branch"),
+		PR:      q.Get("pr"),
+		AllRuns: q.Get("runs") == "all",
+		Labels:  parseLabels(q["label"]),
+	}
+	var err error
+	if f.From, err = parseTime(q.Get("from")); err != nil {
+		return f, fmt.Errorf("invalid from: %v", err)
+	}
+	if f.To, err = parseTime(q.Get("to")); err != nil {
+		return f, fmt.Errorf("invalid to: %v", err)
+	}
+	if f.Window, err = parseCount(q.Get("window")); err != nil {
+		return f, fmt.Errorf("invalid window: %v", err)
+	}
+	if f.MinRuns, err = parseCount(q.Get("min_runs")); err != nil {
+		return f, fmt.Errorf("invalid min_runs: %v", err)
+	}
+	return f, nil
+}
+
+// parseTime parses a 2006-01-02 date or a RFC3339 time, the zero time if s is empty
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseCount parses a positive number, 0 if s is empty
+func parseCount(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%d is not positive", n)
+	}
+	return n, nil
+}
+
+// parseLabels parses key=value label query parameters
+func parseLabels(values []string) models.Labels {
+	if len(values) == 0 {
+		return nil
+	}
+	labels := models.Labels{}
+	for _, v := range values {
+		key, value, _ := strings.Cut(v, "=")
+		labels[key] = value
+	}
+	return labels
+}
+
+// writeJSON writes data as a JSON HTTP response, a nil map means the backend does not support the query
+func writeJSON(w http.ResponseWriter, data map[string]interface{}) {
+	if data == nil {
+		http.Error(w, "not supported by the database backend", http.StatusNotImplemented)
+		return
+	}
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
@@ -162,9 +324,3 @@ func ServeGopoghVersion(w http.ResponseWriter, _ *http.Request) {
 		return
 	}
 }
-
-func ServeHTML(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	fmt.Fprint(w, flakeChartHTML)
-}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/parser"
+	"github.com/medyagh/gopogh/pkg/report"
+)
+
+// maxIngestSize is the largest test2json stream or summary accepted by the ingest endpoint
+const maxIngestSize = 512 << 20
+
+// anyProject is the project of a token that is allowed to upload to all projects
+const anyProject = "*"
+
+// ParseTokens parses a comma separated list of project=token pairs into a map of token to project
+func ParseTokens(s string) (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		project, token, ok := strings.Cut(pair, "=")
+		if !ok || token == "" {
+			return nil, fmt.Errorf("invalid ingest token %q, expected project=token", pair)
+		}
+		if _, ok := tokens[token]; ok {
+			return nil, fmt.Errorf("ingest token of %q is also given to another project", project)
+		}
+		tokens[token] = project
+	}
+	return tokens, nil
+}
+
+// ServeIngest stores a test2json stream or a json summary sent by a CI job in the database.
+// A json summary carries its own report details, for a test2json stream they are taken from the
+// name, pr, details, commit, parent_commit, commit_order, repo, branch, go_version, goos, goarch, hostname, trigger and label (key=value) query parameters. Non-empty query parameters override the summary.
+func (m *DB) ServeIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	project, ok := m.authorize(r)
+	if !ok {
+		http.Error(w, "missing or invalid ingest token", http.StatusUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxIngestSize)
+
+	c, err := contentFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c.Detail.Name == "" {
+		http.Error(w, "missing environment name", http.StatusUnprocessableEntity)
+		return
+	}
+	if c.Detail.CommitID() == "" {
+		http.Error(w, "missing commit", http.StatusUnprocessableEntity)
+		return
+	}
+	if c.Detail.RepoName == "" && project != anyProject {
+		c.Detail.RepoName = project
+	}
+	if project != anyProject && c.Detail.RepoName != project {
+		http.Error(w, fmt.Sprintf("token is not allowed to upload to %q", c.Detail.RepoName), http.StatusForbidden)
+		return
+	}
+
+	if m.Owners != nil {
+		c.SetOwners(m.Owners)
+	}
+	envRow, testRows := c.DBRows()
+	if err := m.Database.Set(r.Context(), envRow, testRows); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if m.Cache != nil {
+		m.Cache.Invalidate()
+	}
+	if m.Alerts != nil {
+		// the alerts are evaluated after the response is sent, so not with the context of the request
+		go m.Alerts.Run(context.Background(), envRow, testRows)
+	}
+	writeJSON(w, map[string]interface{}{
+		"project":      envRow.Project,
+		"envName":      envRow.EnvName,
+		"commitID":     envRow.CommitID,
+		"numberOfFail": envRow.NumberOfFail,
+		"numberOfPass": envRow.NumberOfPass,
+		"numberOfSkip": envRow.NumberOfSkip,
+	})
+}
+
+// authorize returns the project of the bearer token of the request
+func (m *DB) authorize(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	// the tokens are compared in constant time so the response time does not tell how much of a token is right
+	for t, project := range m.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return project, true
+		}
+	}
+	return "", false
+}
+
+// contentFromRequest builds the report of an ingest request
+func contentFromRequest(r *http.Request) (report.DisplayContent, error) {
+	q := r.URL.Query()
+	detail := models.ReportDetail{
+		Name:         q.Get("name"),
+		Details:      q.Get("details"),
+		Commit:       q.Get("commit"),
+		ParentCommit: q.Get("parent_commit"),
+		PR:           q.Get("pr"),
+		RepoName:     q.Get("repo"),
+		Branch:       q.Get("branch"),
+		Run: models.RunMetadata{
+			GoVersion: q.Get("go_version"),
+			GOOS:      q.Get("goos"),
+			GOARCH:    q.Get("goarch"),
+			Hostname:  q.Get("hostname"),
+			Trigger:   q.Get("trigger"),
+			Labels:    parseLabels(q["label"]),
+		},
+	}
+	if s := q.Get("commit_order"); s != "" {
+		order, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return report.DisplayContent{}, fmt.Errorf("invalid commit order %q: %v", s, err)
+		}
+		detail.CommitOrder = order
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return report.DisplayContent{}, fmt.Errorf("failed to read summary: %v", err)
+		}
+		c, err := report.FromSummary(b, time.Now())
+		if err != nil {
+			return report.DisplayContent{}, err
+		}
+		overrideDetail(&c.Detail, detail)
+		return c, nil
+	}
+
+	events, err := parser.ParseJSONReader(r.Body)
+	if err != nil {
+		return report.DisplayContent{}, fmt.Errorf("failed to parse test2json stream: %v", err)
+	}
+	return report.Generate(detail, parser.ProcessEvents(events))
+}
+
+// overrideDetail replaces the fields of d with the non-empty fields of o
+func overrideDetail(d *models.ReportDetail, o models.ReportDetail) {
+	if o.Name != "" {
+		d.Name = o.Name
+	}
+	if o.Details != "" {
+		d.Details = o.Details
+	}
+	if o.Commit != "" {
+		d.Commit = o.Commit
+	}
+	if o.ParentCommit != "" {
+		d.ParentCommit = o.ParentCommit
+	}
+	if o.CommitOrder != 0 {
+		d.CommitOrder = o.CommitOrder
+	}
+	if o.PR != "" {
+		d.PR = o.PR
+	}
+	if o.RepoName != "" {
+		d.RepoName = o.RepoName
+	}
+	if o.Branch != "" {
+		d.Branch = o.Branch
+	}
+	if o.Run.GoVersion != "" {
+		d.Run.GoVersion = o.Run.GoVersion
+	}
+	if o.Run.GOOS != "" {
+		d.Run.GOOS = o.Run.GOOS
+	}
+	if o.Run.GOARCH != "" {
+		d.Run.GOARCH = o.Run.GOARCH
+	}
+	if o.Run.Hostname != "" {
+		d.Run.Hostname = o.Run.Hostname
+	}
+	if o.Run.Trigger != "" {
+		d.Run.Trigger = o.Run.Trigger
+	}
+	for k, v := range o.Run.Labels {
+		if d.Run.Labels == nil {
+			d.Run.Labels = models.Labels{}
+		}
+		d.Run.Labels[k] = v
+	}
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/parser"
+	"github.com/medyagh/gopogh/pkg/report"
+	"github.com/medyagh/gopogh/pkg/store"
+)
+
+const (
+	htmlExt = ".html"
+	jsonExt = ".json"
+)
+
+// ServeReport stores (POST) and serves (GET) the full report of a run at /report/{env}/{commit}?project={project}.
+// A report can be uploaded as generated HTML or as the raw test2json stream, which is rendered when served.
+// If nothing is stored for the run the request is redirected to the ReportFallback url when one is configured.
+func (m *DB) ServeReport(w http.ResponseWriter, r *http.Request) {
+	env, commit, ok := parseReportPath(r.URL.Path)
+	if !ok {
+		http.Error(w, "expected /report/{env}/{commit}", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		m.getReport(w, r, m.project(r), env, commit)
+	case http.MethodPost, http.MethodPut:
+		m.putReport(w, r, m.project(r), env, commit)
+	default:
+		http.Error(w, "only GET and POST are supported", http.StatusMethodNotAllowed)
+	}
+}
+
+func (m *DB) getReport(w http.ResponseWriter, r *http.Request, project, env, commit string) {
+	if m.Reports != nil {
+		html, err := m.Reports.Get(reportKey(project, env, commit, htmlExt))
+		if err == nil {
+			defer html.Close()
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if _, err := io.Copy(w, html); err != nil {
+				http.Error(w, "Failed to write report", http.StatusInternalServerError)
+			}
+			return
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		raw, err := m.Reports.Get(reportKey(project, env, commit, jsonExt))
+		if err == nil {
+			defer raw.Close()
+			b, err := renderReport(raw, env, commit)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if _, err := w.Write(b); err != nil {
+				http.Error(w, "Failed to write report", http.StatusInternalServerError)
+			}
+			return
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if m.ReportFallback != "" {
+		u := strings.NewReplacer("{env}", url.PathEscape(env), "{commit}", url.PathEscape(commit)).Replace(m.ReportFallback)
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("no report stored for %s on %s", commit, env), http.StatusNotFound)
+}
+
+func (m *DB) putReport(w http.ResponseWriter, r *http.Request, project, env, commit string) {
+	tokenProject, ok := m.authorize(r)
+	if !ok {
+		http.Error(w, "missing or invalid ingest token", http.StatusUnauthorized)
+		return
+	}
+	// like ingestion, a report without a project goes to the project of the token
+	if project == "" && tokenProject != anyProject {
+		project = tokenProject
+	}
+	if tokenProject != anyProject && tokenProject != project {
+		http.Error(w, fmt.Sprintf("token is not allowed to upload to %q", project), http.StatusForbidden)
+		return
+	}
+	if m.Reports == nil {
+		http.Error(w, "report storage is not configured", http.StatusNotImplemented)
+		return
+	}
+	ext := jsonExt
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/html") {
+		ext = htmlExt
+	}
+	body := http.MaxBytesReader(w, r.Body, maxIngestSize)
+	if err := m.Reports.Put(reportKey(project, env, commit, ext), body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"project":  project,
+		"envName":  env,
+		"commitID": commit,
+	})
+}
+
+// parseReportPath splits /report/{env}/{commit} into the environment and commit
+func parseReportPath(path string) (env, commit string, ok bool) {
+	rest := strings.TrimPrefix(path, "/report/")
+	i := strings.LastIndex(rest, "/")
+	if rest == path || i <= 0 || i == len(rest)-1 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// reportKey is the store key of the report of a run, reports of the ” project are stored at the top level
+func reportKey(project, env, commit, ext string) string {
+	key := url.QueryEscape(env) + "/" + url.QueryEscape(commit) + ext
+	if project != "" {
+		key = url.QueryEscape(project) + "/" + key
+	}
+	return key
+}
+
+// renderReport generates the HTML report of a raw test2json stream
+func renderReport(raw io.Reader, env, commit string) ([]byte, error) {
+	events, err := parser.ParseJSONReader(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stored test2json: %v", err)
+	}
+	c, err := report.Generate(models.ReportDetail{Name: env, Commit: commit}, parser.ProcessEvents(events))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate report: %v", err)
+	}
+	return c.HTML()
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/export"
+)
+
+// ServeRuns writes a page of the stored runs selected by the filter and the env and commit query parameters, newest first,
+// as JSON or as CSV with format=csv. The cursor query parameter selects the page after the one that returned it.
+func (m *DB) ServeRuns(w http.ResponseWriter, r *http.Request) {
+	f, q, err := m.rowQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	page, err := m.Database.GetRuns(r.Context(), f, q)
+	if err != nil {
+		writeRowsError(w, err)
+		return
+	}
+	if page == nil {
+		http.Error(w, "not supported by the database backend", http.StatusNotImplemented)
+		return
+	}
+	if r.URL.Query().Get("format") != "csv" {
+		writePage(w, page)
+		return
+	}
+	records := make([][]string, 0, len(page.Runs))
+	for _, run := range page.Runs {
+		records = append(records, export.RunRecord(run))
+	}
+	writeCSV(w, page.NextCursor, export.RunColumns, records)
+}
+
+// ServeTestCases writes a page of the stored test cases selected by the filter and the env, test, result and commit query parameters,
+// newest first, as JSON or as CSV with format=csv. The cursor query parameter selects the page after the one that returned it.
+func (m *DB) ServeTestCases(w http.ResponseWriter, r *http.Request) {
+	f, q, err := m.rowQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	page, err := m.Database.GetTestCases(r.Context(), f, q)
+	if err != nil {
+		writeRowsError(w, err)
+		return
+	}
+	if page == nil {
+		http.Error(w, "not supported by the database backend", http.StatusNotImplemented)
+		return
+	}
+	if r.URL.Query().Get("format") != "csv" {
+		writePage(w, page)
+		return
+	}
+	records := make([][]string, 0, len(page.TestCases))
+	for _, tc := range page.TestCases {
+		records = append(records, export.TestCaseRecord(tc))
+	}
+	writeCSV(w, page.NextCursor, export.TestCaseColumns, records)
+}
+
+// ServeExport streams all of the runs (/export/runs) or test cases (/export/tests) selected by the same query parameters
+// as ServeRuns and ServeTestCases as CSV, or as Parquet with format=parquet
+func (m *DB) ServeExport(w http.ResponseWriter, r *http.Request) {
+	f, q, err := m.rowQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.CSV
+	}
+	var contentType string
+	switch format {
+	case export.CSV:
+		contentType = "text/csv; charset=utf-8"
+	case export.Parquet:
+		contentType = "application/vnd.apache.parquet"
+	default:
+		http.Error(w, fmt.Sprintf("unknown export format %q, expected csv or parquet", format), http.StatusUnprocessableEntity)
+		return
+	}
+	table := path.Base(r.URL.Path)
+	var write func(context.Context, io.Writer, db.Datab, db.Filter, db.RowQuery, string) (int, error)
+	switch table {
+	case "runs":
+		write = export.Runs
+	case "tests":
+		write = export.TestCases
+	default:
+		http.Error(w, fmt.Sprintf("unknown table %q, expected runs or tests", table), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=gopogh-%s.%s", table, format))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// nothing is written before the first page is read, so the errors of the query can still be reported
+	out := &startedWriter{w: w}
+	if _, err := write(r.Context(), out, m.Database, f, q, format); err != nil {
+		log.Printf("failed to export %s: %v", table, err)
+		if out.started {
+			// the status is sent already, aborting the response tells the client the file is incomplete
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Del("Content-Disposition")
+		switch {
+		case errors.Is(err, export.ErrNotSupported):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		case errors.Is(err, db.ErrInvalidCursor):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// startedWriter tells whether anything was written to the response
+type startedWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		s.started = true
+	}
+	return s.w.Write(p)
+}
+
+// rowQuery returns the filter and the row query of a request
+func (m *DB) rowQuery(r *http.Request) (db.Filter, db.RowQuery, error) {
+	f, err := m.filter(r)
+	if err != nil {
+		return f, db.RowQuery{}, err
+	}
+	values := r.URL.Query()
+	q := db.RowQuery{
+		Env:    values.Get("env"),
+		Test:   values.Get("test"),
+		Result: values.Get("result"),
+		Commit: values.Get("commit"),
+		Cursor: values.Get("cursor"),
+	}
+	switch q.Result {
+	case "", "pass", "fail", "skip":
+	default:
+		return f, q, fmt.Errorf("invalid result %q, expected pass, fail or skip", q.Result)
+	}
+	if q.Limit, err = parseCount(values.Get("limit")); err != nil {
+		return f, q, fmt.Errorf("invalid limit: %v", err)
+	}
+	if q.Limit > db.MaxPageSize {
+		return f, q, fmt.Errorf("invalid limit: at most %d rows can be requested", db.MaxPageSize)
+	}
+	return f, q, nil
+}
+
+// writeRowsError writes the error of a row query, a bad cursor is the client's error
+func writeRowsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// writePage writes a page of rows as a JSON HTTP response
+func writePage(w http.ResponseWriter, page interface{}) {
+	jsonData, err := json.Marshal(page)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, _ = w.Write(jsonData)
+}
+
+// writeCSV writes the rows as a CSV HTTP response, with the cursor of the next page in the X-Next-Cursor header
+func writeCSV(w http.ResponseWriter, nextCursor string, columns []string, records [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor")
+	if nextCursor != "" {
+		w.Header().Set("X-Next-Cursor", nextCursor)
+	}
+	cw := csv.NewWriter(w)
+	_ = cw.Write(columns)
+	_ = cw.WriteAll(records)
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// v2Prefix is the path prefix of the endpoints returning the commits of a chart point as json arrays.
+// The endpoints without it keep returning them as the "sha: result" strings older clients split.
+const v2Prefix = "/v2/"
+
+// flakeByV1 is models.DBFlakeBy with the commits as a "sha: result, ..." string
+type flakeByV1 struct {
+	TestName        string    `json:"testName"`
+	StartOfDate     time.Time `json:"startOfDate"`
+	FlakePercentage float32   `json:"flakePercentage"`
+	CommitResults   string    `json:"commitResults"`
+}
+
+// envDurationV1 is models.DBEnvDuration with the commits as "sha: count, ..." and "sha: duration, ..." strings
+type envDurationV1 struct {
+	StartOfDate     time.Time `json:"startOfDate"`
+	TestCount       float32   `json:"testCount"`
+	Duration        float32   `json:"duration"`
+	CommitCounts    string    `json:"commitCounts"`
+	CommitDurations string    `json:"commitDurations"`
+}
+
+// testRateAndDurationV1 is models.DBTestRateAndDuration with the commits as a "sha: result: duration, ..." string
+type testRateAndDurationV1 struct {
+	StartOfDate               time.Time `json:"startOfDate"`
+	AvgDuration               float32   `json:"avgDuration"`
+	FlakePercentage           float32   `json:"flakePercentage"`
+	CommitResultsAndDurations string    `json:"commitResultsAndDurations"`
+}
+
+// versioned returns the data in the format of the api version of the request path
+func versioned(r *http.Request, data map[string]interface{}) map[string]interface{} {
+	if data == nil || strings.HasPrefix(r.URL.Path, v2Prefix) {
+		return data
+	}
+	v1 := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		v1[k] = toV1(v)
+	}
+	return v1
+}
+
+// toV1 converts the chart rows with json commit arrays to the v1 rows
+func toV1(v interface{}) interface{} {
+	switch rows := v.(type) {
+	case []models.DBFlakeBy:
+		v1 := make([]flakeByV1, len(rows))
+		for i, r := range rows {
+			v1[i] = flakeByV1{
+				TestName:        r.TestName,
+				StartOfDate:     r.StartOfDate,
+				FlakePercentage: r.FlakePercentage,
+				CommitResults:   joinCommits(len(r.Commits), func(j int) string { return r.Commits[j].Commit + ": " + r.Commits[j].Result }),
+			}
+		}
+		return v1
+	case []models.DBEnvDuration:
+		v1 := make([]envDurationV1, len(rows))
+		for i, r := range rows {
+			v1[i] = envDurationV1{
+				StartOfDate:     r.StartOfDate,
+				TestCount:       r.TestCount,
+				Duration:        r.Duration,
+				CommitCounts:    joinCommits(len(r.Commits), func(j int) string { return r.Commits[j].Commit + ": " + strconv.Itoa(r.Commits[j].TestCount) }),
+				CommitDurations: joinCommits(len(r.Commits), func(j int) string { return r.Commits[j].Commit + ": " + formatFloat(r.Commits[j].Duration) }),
+			}
+		}
+		return v1
+	case []models.DBTestRateAndDuration:
+		v1 := make([]testRateAndDurationV1, len(rows))
+		for i, r := range rows {
+			v1[i] = testRateAndDurationV1{
+				StartOfDate:     r.StartOfDate,
+				AvgDuration:     r.AvgDuration,
+				FlakePercentage: r.FlakePercentage,
+				CommitResultsAndDurations: joinCommits(len(r.Commits), func(j int) string {
+					return r.Commits[j].Commit + ": " + r.Commits[j].Result + ": " + formatFloat(r.Commits[j].Duration)
+				}),
+			}
+		}
+		return v1
+	default:
+		return v
+	}
+}
+
+// joinCommits joins the n formatted commits the way STRING_AGG(..., ', ') did
+func joinCommits(n int, format func(int) string) string {
+	s := make([]string, n)
+	for i := range s {
+		s[i] = format(i)
+	}
+	return strings.Join(s, ", ")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,242 @@
+// Package importer backfills the database from archived test2json logs
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/owners"
+	"github.com/medyagh/gopogh/pkg/parser"
+	"github.com/medyagh/gopogh/pkg/report"
+)
+
+// Run is the manifest entry of an archived test2json log
+type Run struct {
+	// File is the path of the log relative to the imported directory
+	File string `json:"file"`
+	// Env is the name of the environment the tests ran on, like -name
+	Env    string `json:"env"`
+	Commit string `json:"commit"`
+	PR     string `json:"pr"`
+	// Time is the time the tests ran at, the time of the first test of the log if not set
+	Time time.Time `json:"time"`
+	// Repo and Branch default to the ones of the manifest
+	Repo         string        `json:"repo"`
+	Branch       string        `json:"branch"`
+	Details      string        `json:"details"`
+	ParentCommit string        `json:"parentCommit"`
+	CommitOrder  int64         `json:"commitOrder"`
+	Labels       models.Labels `json:"labels"`
+}
+
+// Manifest describes the archived test2json logs of a directory
+type Manifest struct {
+	// Repo and Branch are the source repo and branch of the runs that do not set theirs
+	Repo   string `json:"repo"`
+	Branch string `json:"branch"`
+	Runs   []Run  `json:"runs"`
+}
+
+// Setter stores the rows of a run, implemented by db.Datab
+type Setter interface {
+	Set(context.Context, models.DBEnvironmentTest, []models.DBTestCase) error
+}
+
+// Result is the outcome of an import
+type Result struct {
+	// Imported is the number of runs stored
+	Imported int
+	// Unlisted are the logs of the directory without a manifest entry, they are not imported
+	Unlisted []string
+	// Failed maps the logs that could not be imported to their error
+	Failed map[string]error
+}
+
+// LoadManifest reads a json manifest
+func LoadManifest(path string) (Manifest, error) {
+	var m Manifest
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return m, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	seen := map[string]bool{}
+	for i, r := range m.Runs {
+		if r.File == "" {
+			return m, fmt.Errorf("run %d of the manifest has no file", i)
+		}
+		if r.Env == "" {
+			return m, fmt.Errorf("run %s of the manifest has no env", r.File)
+		}
+		if r.Commit == "" && r.Details == "" {
+			return m, fmt.Errorf("run %s of the manifest has no commit", r.File)
+		}
+		f := filepath.Clean(r.File)
+		if seen[f] {
+			return m, fmt.Errorf("file %s is listed twice in the manifest", r.File)
+		}
+		seen[f] = true
+		m.Runs[i].File = f
+	}
+	return m, nil
+}
+
+// Importer parses the logs of a manifest and stores them
+type Importer struct {
+	Database Setter
+	// Owners sets the owners of the tests, nil to not set them
+	Owners *owners.Owners
+	// Workers is the number of logs parsed at the same time, 1 if not set
+	Workers int
+}
+
+type parsed struct {
+	file     string
+	env      models.DBEnvironmentTest
+	testRows []models.DBTestCase
+	err      error
+}
+
+// Import walks dir and stores the runs of the *.json logs listed in the manifest.
+// The logs are parsed concurrently by Workers goroutines and stored one run at a time, as a run is
+// stored by upserting it, importing the same logs again does not duplicate them.
+// Once ctx is done the remaining logs are not imported and the error of ctx is returned with the result so far.
+func (i *Importer) Import(ctx context.Context, dir string, m Manifest) (*Result, error) {
+	runs := map[string]Run{}
+	for _, r := range m.Runs {
+		runs[r.File] = r
+	}
+	res := &Result{Failed: map[string]error{}}
+	var todo []Run
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		r, ok := runs[rel]
+		if !ok {
+			res.Unlisted = append(res.Unlisted, rel)
+			return nil
+		}
+		delete(runs, rel)
+		todo = append(todo, r)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %v", dir, err)
+	}
+	for f := range runs {
+		res.Failed[f] = fmt.Errorf("listed in the manifest but not found")
+	}
+
+	workers := i.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	jobs := make(chan Run)
+	results := make(chan parsed)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				results <- i.parse(dir, m, r)
+			}
+		}()
+	}
+	go func() {
+	feed:
+		for _, r := range todo {
+			select {
+			case jobs <- r:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	// the runs are stored by a single goroutine so the upserts of the daily rollups do not contend
+	for p := range results {
+		if ctx.Err() != nil {
+			// the runs parsed before the import stopped are not stored, drained so the workers finish
+			continue
+		}
+		if p.err != nil {
+			res.Failed[p.file] = p.err
+			continue
+		}
+		if err := i.Database.Set(ctx, p.env, p.testRows); err != nil {
+			res.Failed[p.file] = fmt.Errorf("failed to store: %v", err)
+			continue
+		}
+		res.Imported++
+		log.Printf("imported %s (%s %s)", p.file, p.env.EnvName, p.env.CommitID)
+	}
+	sort.Strings(res.Unlisted)
+	return res, ctx.Err()
+}
+
+// parse generates the report of the log of a run and returns its database rows
+func (i *Importer) parse(dir string, m Manifest, r Run) parsed {
+	p := parsed{file: r.File}
+	events, err := parser.ParseJSON(filepath.Join(dir, r.File))
+	if err != nil {
+		p.err = fmt.Errorf("failed to parse: %v", err)
+		return p
+	}
+	if len(events) == 0 {
+		p.err = fmt.Errorf("no test2json events")
+		return p
+	}
+	detail := models.ReportDetail{
+		Name:         r.Env,
+		Details:      r.Details,
+		Commit:       r.Commit,
+		ParentCommit: r.ParentCommit,
+		CommitOrder:  r.CommitOrder,
+		PR:           r.PR,
+		RepoName:     r.Repo,
+		Branch:       r.Branch,
+		Run:          models.RunMetadata{Trigger: "import", Labels: r.Labels},
+	}
+	if detail.RepoName == "" {
+		detail.RepoName = m.Repo
+	}
+	if detail.Branch == "" {
+		detail.Branch = m.Branch
+	}
+	c, err := report.Generate(detail, parser.ProcessEvents(events))
+	if err != nil {
+		p.err = fmt.Errorf("failed to generate report: %v", err)
+		return p
+	}
+	if !r.Time.IsZero() {
+		c.TestTime = r.Time
+	}
+	if i.Owners != nil {
+		c.SetOwners(i.Owners)
+	}
+	p.env, p.testRows = c.DBRows()
+	return p
+}
@@ -0,0 +1,84 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/report"
+)
+
+// ImportSummaries walks dir and stores the runs of the *.json summaries written by gopogh -out_summary, the other json files of the directory fail to import.
+// seen maps the summaries scanned before to their modification time, the unchanged ones are skipped and the scanned ones are added,
+// so a failed summary is tried again once it changes. Like Import it does not duplicate the runs imported again.
+// Once ctx is done the remaining summaries are not imported and the error of ctx is returned with the result so far.
+func (i *Importer) ImportSummaries(ctx context.Context, dir string, seen map[string]time.Time) (*Result, error) {
+	res := &Result{Failed: map[string]error{}}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if t, ok := seen[rel]; ok && t.Equal(info.ModTime()) {
+			return nil
+		}
+		seen[rel] = info.ModTime()
+		if err := i.importSummary(ctx, path, info.ModTime()); err != nil {
+			res.Failed[rel] = err
+			return nil
+		}
+		res.Imported++
+		log.Printf("imported %s", rel)
+		return nil
+	})
+	if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %v", dir, err)
+	}
+	return res, nil
+}
+
+// importSummary stores the run of a summary, the summaries without a test time ran at modTime
+func (i *Importer) importSummary(ctx context.Context, path string, modTime time.Time) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read: %v", err)
+	}
+	c, err := report.FromSummary(b, modTime)
+	if err != nil {
+		return err
+	}
+	if c.Detail.Name == "" {
+		return fmt.Errorf("not a gopogh summary, it has no environment name")
+	}
+	if c.Detail.CommitID() == "" {
+		return fmt.Errorf("the summary has no commit")
+	}
+	if i.Owners != nil {
+		c.SetOwners(i.Owners)
+	}
+	env, testRows := c.DBRows()
+	if err := i.Database.Set(ctx, env, testRows); err != nil {
+		return fmt.Errorf("failed to store: %v", err)
+	}
+	return nil
+}
@@ -1,14 +1,106 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 // ReportDetail holds the report details such as test name, PR number...
 type ReportDetail struct {
-	Name     string
-	Details  string
-	PR       string // pull request number
-	RepoName string // for example github repo
+	Name         string
+	Details      string // free form details, for example test args
+	Commit       string // commit sha the tests ran on
+	ParentCommit string // sha of the parent of the commit
+	CommitOrder  int64  // position of the commit in the branch history, for example 'git rev-list --count HEAD'
+	PR           string // pull request number
+	RepoName     string // for example github repo
+	Branch       string // branch the tests ran on, for example master
+	Run          RunMetadata
 }
+
+// CommitID returns the commit the tests ran on. Reports from before -commit existed
+// passed the sha as details, so the details are used as a fallback like they were before.
+// It is empty if neither is set, such runs cannot be stored as the commit is part of their key
+func (d ReportDetail) CommitID() string {
+	if d.Commit == "" {
+		return d.Details
+	}
+	return d.Commit
+}
+
+// RunMetadata describes where and why the tests ran
+type RunMetadata struct {
+	GoVersion string
+	GOOS      string
+	GOARCH    string
+	Hostname  string // hostname of the runner
+	Trigger   string // what started the run, for example push, schedule or pull_request
+	Labels    Labels
+}
+
+// Labels are arbitrary key=value pairs attached to a run, stored as a json object
+type Labels map[string]string
+
+// Value implements driver.Valuer
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(l)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (l *Labels) Scan(src interface{}) error {
+	return scanJSON(src, l)
+}
+
+// scanJSON unmarshals a json column into v
+func scanJSON(src interface{}, v interface{}) error {
+	switch s := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(s, v)
+	case string:
+		return json.Unmarshal([]byte(s), v)
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, v)
+	}
+}
+
+// CommitResult is the result of a test in the run of a commit
+type CommitResult struct {
+	Commit   string  `json:"commit"`
+	Result   string  `json:"result"`
+	Duration float64 `json:"duration"`
+}
+
+// CommitResults are the results of the runs aggregated into a chart point, stored as a json array
+type CommitResults []CommitResult
+
+// Scan implements sql.Scanner
+func (c *CommitResults) Scan(src interface{}) error {
+	return scanJSON(src, c)
+}
+
+// CommitRun is the number of tests and the duration of the run of a commit
+type CommitRun struct {
+	Commit    string  `json:"commit"`
+	TestCount int     `json:"testCount"`
+	Duration  float64 `json:"duration"`
+}
+
+// CommitRuns are the runs aggregated into a chart point, stored as a json array
+type CommitRuns []CommitRun
+
+// Scan implements sql.Scanner
+func (c *CommitRuns) Scan(src interface{}) error {
+	return scanJSON(src, c)
+}
+
 type TestEvent struct {
 	Time    time.Time // encodes as an RFC3339-format string
 	Action  string
@@ -33,9 +125,12 @@ type TestGroup struct {
 
 // DBTestCase represents a row in db table that holds each individual subtest
 type DBTestCase struct {
+	Project   string
+	Branch    string
 	PR        string
 	CommitID  string
 	TestName  string
+	Owner     string
 	TestTime  time.Time
 	Result    string
 	Duration  float64
@@ -45,7 +140,13 @@ type DBTestCase struct {
 
 // DBEnvironmentTest represents a row in db table that has finished tests in each environment
 type DBEnvironmentTest struct {
+	Project       string
+	Branch        string
+	PR            string
 	CommitID      string
+	Details       string
+	ParentCommit  string
+	CommitOrder   int64
 	EnvName       string
 	GopoghTime    time.Time
 	TestTime      time.Time
@@ -54,6 +155,72 @@ type DBEnvironmentTest struct {
 	NumberOfSkip  int
 	TotalDuration float64
 	GopoghVersion string
+	GoVersion     string
+	GOOS          string
+	GOARCH        string
+	Hostname      string
+	Trigger       string
+	Labels        Labels
+}
+
+// DBTestFailures are the number of runs and failures of a test on an environment
+type DBTestFailures struct {
+	TestName string
+	EnvName  string
+	Runs     int
+	Fails    int
+	// FailedCommits are the commits the test failed at, newest first
+	FailedCommits StringList
+}
+
+// DBRunsPage is a page of the stored runs
+type DBRunsPage struct {
+	Runs []DBEnvironmentTest
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string
+}
+
+// DBTestCasesPage is a page of the stored test cases
+type DBTestCasesPage struct {
+	TestCases []DBTestCase
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string
+}
+
+// DBPruneResult are the number of rows deleted by a prune
+type DBPruneResult struct {
+	TestCases        int64
+	EnvironmentTests int64
+}
+
+// DBDailyTrend represents a day of the long-term trend of a test or an environment
+type DBDailyTrend struct {
+	Day             time.Time `json:"day"`
+	Runs            int       `json:"runs"`
+	Fails           int       `json:"fails"`
+	FlakePercentage float32   `json:"flakePercentage"`
+	AvgDuration     float32   `json:"avgDuration"`
+}
+
+// StringList is a list of strings stored as a json array
+type StringList []string
+
+// Scan implements sql.Scanner
+func (s *StringList) Scan(src interface{}) error {
+	return scanJSON(src, s)
+}
+
+// DBRunStats are the stats of the runs of an environment and branch before a run, used to evaluate alert rules
+type DBRunStats struct {
+	// PrevCommit is the commit of the previous run, PrevResults maps its tests to their result
+	PrevCommit  string
+	PrevResults map[string]string
+	// TestRuns and TestFails are the number of non-skipped runs and failures of each test since the start of the window
+	TestRuns  map[string]int
+	TestFails map[string]int
+	// EnvRuns is the number of runs since the start of the window and AvgFails their average NumberOfFail
+	EnvRuns  int
+	AvgFails float64
 }
 
 // DBFlakeRow represents a row in the basic flake rate table
@@ -61,31 +228,83 @@ type DBFlakeRow struct {
 	TestName              string  `json:"testName"`
 	RecentFlakePercentage float32 `json:"recentFlakePercentage"`
 	GrowthRate            float32 `json:"growthRate"`
+	RecentRuns            int     `json:"recentRuns"`
+	RecentFails           int     `json:"recentFails"`
+	// ConfidenceLow and ConfidenceHigh bound the flake percentage with 95% confidence given the number of runs
+	ConfidenceLow  float32 `json:"confidenceLow"`
+	ConfidenceHigh float32 `json:"confidenceHigh"`
+	// FlakinessScore is the percentage of runs that flipped from pass to fail and back, failures on consecutive commits are not flakes
+	FlakinessScore float32 `json:"flakinessScore"`
+	// FailStreak is the number of consecutive failures on the newest commits
+	FailStreak int `json:"failStreak"`
+	// Classification is broken (failing on the last commits), flaky or stable
+	Classification string `json:"classification"`
 }
 
 // DBFlakeBy represents a "row" in the flake rate by _ of top 10 of recent test flakiness charts
 type DBFlakeBy struct {
-	TestName        string    `json:"testName"`
-	StartOfDate     time.Time `json:"startOfDate"`
-	FlakePercentage float32   `json:"flakePercentage"`
-	CommitResults   string    `json:"commitResults"`
+	TestName        string        `json:"testName"`
+	StartOfDate     time.Time     `json:"startOfDate"`
+	FlakePercentage float32       `json:"flakePercentage"`
+	Commits         CommitResults `json:"commits"`
 }
 
 // DBEnvDuration represents a "row" in the test count and total duration by day chart
 type DBEnvDuration struct {
-	StartOfDate     time.Time `json:"startOfDate"`
-	TestCount       float32   `json:"testCount"`
-	Duration        float32   `json:"duration"`
-	CommitCounts    string    `json:"commitCounts"`
-	CommitDurations string    `json:"commitDurations"`
+	StartOfDate time.Time  `json:"startOfDate"`
+	TestCount   float32    `json:"testCount"`
+	Duration    float32    `json:"duration"`
+	Commits     CommitRuns `json:"commits"`
 }
 
 // DBTestRateAndDuration represents a "row" in the flake rate and duration chart for a given test
 type DBTestRateAndDuration struct {
-	StartOfDate               time.Time `json:"startOfDate"`
-	AvgDuration               float32   `json:"avgDuration"`
-	FlakePercentage           float32   `json:"flakePercentage"`
-	CommitResultsAndDurations string    `json:"commitResultsAndDurations"`
+	StartOfDate     time.Time     `json:"startOfDate"`
+	AvgDuration     float32       `json:"avgDuration"`
+	FlakePercentage float32       `json:"flakePercentage"`
+	Commits         CommitResults `json:"commits"`
+}
+
+// DBTestHistory represents the result of a test on a commit in the commit-ordered history
+type DBTestHistory struct {
+	CommitID     string    `json:"commitId"`
+	ParentCommit string    `json:"parentCommit"`
+	CommitOrder  int64     `json:"commitOrder"`
+	TestTime     time.Time `json:"testTime"`
+	Result       string    `json:"result"`
+	Duration     float32   `json:"duration"`
+}
+
+// DBDurationRegression represents a test whose recent median duration on an environment exceeds its baseline
+type DBDurationRegression struct {
+	EnvName        string  `json:"envName"`
+	TestName       string  `json:"testName"`
+	BaselineMedian float32 `json:"baselineMedian"`
+	RecentMedian   float32 `json:"recentMedian"`
+	Ratio          float32 `json:"ratio"`
+	BaselineRuns   int     `json:"baselineRuns"`
+	RecentRuns     int     `json:"recentRuns"`
+	// FirstCommit is the commit the duration shifted at
+	FirstCommit string `json:"firstCommit"`
+}
+
+// DBOwnerRow represents a row in the owners table
+type DBOwnerRow struct {
+	Owner           string  `json:"owner"`
+	Tests           int     `json:"tests"`
+	Runs            int     `json:"runs"`
+	Fails           int     `json:"fails"`
+	FlakePercentage float32 `json:"flakePercentage"`
+}
+
+// DBOwnerTest represents the flake rate of a failing test of an owner on an environment
+type DBOwnerTest struct {
+	Owner           string  `json:"owner"`
+	TestName        string  `json:"testName"`
+	EnvName         string  `json:"envName"`
+	Runs            int     `json:"runs"`
+	Fails           int     `json:"fails"`
+	FlakePercentage float32 `json:"flakePercentage"`
 }
 
 // DBSummaryAvgFail represents a "row" in most flakey environments summary chart
@@ -101,4 +320,5 @@ type DBSummaryTable struct {
 	EnvName            string  `json:"envName"`
 	RecentNumberOfFail float32 `json:"recentNumberOfFail"`
 	Growth             float32 `json:"growth"`
+	RecentRuns         int     `json:"recentRuns"`
 }
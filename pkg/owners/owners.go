@@ -0,0 +1,77 @@
+// Package owners maps test names to the teams owning them, from a CODEOWNERS-style file
+package owners
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// rule maps the tests matching a pattern to their owners
+type rule struct {
+	pattern *regexp.Regexp
+	owners  string
+}
+
+// Owners maps test names to their owners. Each line of an owners file is a test name pattern followed by
+// one or more owners, '*' matches any characters and a pattern also matches the subtests of the tests it matches.
+// As in CODEOWNERS the last matching line wins, lines starting with '#' are comments.
+//
+//	# integration tests
+//	Test*                @minikube/maintainers
+//	TestFunctional/*     @minikube/functional
+//	TestNetworkPlugins   @minikube/networking @jane
+type Owners struct {
+	rules []rule
+}
+
+// Load reads an owners file
+func Load(path string) (*Owners, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open owners file: %v", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse parses an owners file
+func Parse(r io.Reader) (*Owners, error) {
+	o := &Owners{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d of owners file has no owner: %q", n, line)
+		}
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(fields[0]), `\*`, `.*`)
+		o.rules = append(o.rules, rule{
+			pattern: regexp.MustCompile(`^` + pattern + `(/.*)?$`),
+			owners:  strings.Join(fields[1:], " "),
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read owners file: %v", err)
+	}
+	return o, nil
+}
+
+// Owner returns the space separated owners of a test, "" if it has none
+func (o *Owners) Owner(test string) string {
+	if o == nil {
+		return ""
+	}
+	for i := len(o.rules) - 1; i >= 0; i-- {
+		if o.rules[i].pattern.MatchString(test) {
+			return o.rules[i].owners
+		}
+	}
+	return ""
+}
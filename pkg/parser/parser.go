@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -18,9 +19,14 @@ func ParseJSON(path string) ([]models.TestEvent, error) {
 		return nil, err
 	}
 	defer f.Close()
+	return ParseJSONReader(f)
+}
 
+// ParseJSONReader is ParseJSON for an already opened test2json stream.
+func ParseJSONReader(r io.Reader) ([]models.TestEvent, error) {
+	var err error
 	events := []models.TestEvent{}
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		// Go's -json output is line-by-line JSON events
 		b := scanner.Bytes()
@@ -2,13 +2,16 @@ package report
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"math"
 	"time"
 
 	"github.com/medyagh/gopogh/pkg/db"
 	"github.com/medyagh/gopogh/pkg/models"
+	"github.com/medyagh/gopogh/pkg/owners"
 	"github.com/medyagh/gopogh/pkg/templates"
 )
 
@@ -21,25 +24,34 @@ type DisplayContent struct {
 	CreatedOn     time.Time
 	Detail        models.ReportDetail
 	TestTime      time.Time
+	// Owners maps the test names to their owners, see SetOwners
+	Owners map[string]string
+}
+
+// Summary is the json summary of a report, see ShortSummary
+type Summary struct {
+	NumberOfTests int
+	NumberOfFail  int
+	NumberOfPass  int
+	NumberOfSkip  int
+	FailedTests   []string
+	PassedTests   []string
+	SkippedTests  []string
+	Durations     map[string]float64
+	TotalDuration float64
+	TestTime      time.Time
+	GopoghVersion string
+	GopoghBuild   string
+	Detail        models.ReportDetail
+	// FailedTestsByOwner groups the failed tests that have an owner by owner
+	FailedTestsByOwner map[string][]string `json:",omitempty"`
+	// Owners maps the tests that have an owner to their owners
+	Owners map[string]string `json:",omitempty"`
 }
 
 // ShortSummary returns only test names without logs
 func (c DisplayContent) ShortSummary() ([]byte, error) {
-	type shortSummary struct {
-		NumberOfTests int
-		NumberOfFail  int
-		NumberOfPass  int
-		NumberOfSkip  int
-		FailedTests   []string
-		PassedTests   []string
-		SkippedTests  []string
-		Durations     map[string]float64
-		TotalDuration float64
-		GopoghVersion string
-		GopoghBuild   string
-		Detail        models.ReportDetail
-	}
-	ss := shortSummary{}
+	ss := Summary{}
 	ss.Durations = make(map[string]float64)
 	for _, t := range resultTypes {
 		if t == pass {
@@ -54,6 +66,12 @@ func (c DisplayContent) ShortSummary() ([]byte, error) {
 			for _, ti := range c.Results[t] {
 				ss.FailedTests = append(ss.FailedTests, ti.TestName)
 				ss.Durations[ti.TestName] = ti.Duration
+				if owner := c.Owner(ti.TestName); owner != "" {
+					if ss.FailedTestsByOwner == nil {
+						ss.FailedTestsByOwner = map[string][]string{}
+					}
+					ss.FailedTestsByOwner[owner] = append(ss.FailedTestsByOwner[owner], ti.TestName)
+				}
 			}
 		}
 		if t == skip {
@@ -69,17 +87,78 @@ func (c DisplayContent) ShortSummary() ([]byte, error) {
 	}
 	ss.NumberOfTests = ss.NumberOfFail + ss.NumberOfPass + ss.NumberOfSkip
 	ss.TotalDuration = c.TotalDuration
+	ss.TestTime = c.TestTime
 	ss.Detail = c.Detail
+	ss.Owners = c.Owners
 	ss.GopoghVersion = Version
 	ss.GopoghBuild = Build
 	return json.MarshalIndent(ss, "", "    ")
 }
 
+// FromSummary rebuilds the display content of a json summary produced by ShortSummary.
+// The rebuilt content has no test logs, so it is only good for the database.
+// Summaries written by older gopogh versions do not have the test time, they get testTime instead.
+func FromSummary(b []byte, testTime time.Time) (DisplayContent, error) {
+	var ss Summary
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return DisplayContent{}, fmt.Errorf("failed to parse summary: %v", err)
+	}
+	if ss.TestTime.IsZero() {
+		ss.TestTime = testTime
+	}
+	order := 0
+	group := func(names []string, status string) []models.TestGroup {
+		groups := make([]models.TestGroup, 0, len(names))
+		for _, n := range names {
+			order++
+			groups = append(groups, models.TestGroup{
+				TestName:  n,
+				TestOrder: order,
+				Status:    status,
+				Duration:  ss.Durations[n],
+			})
+		}
+		return groups
+	}
+	rs := map[string][]models.TestGroup{}
+	rs[pass] = group(ss.PassedTests, pass)
+	rs[fail] = group(ss.FailedTests, fail)
+	rs[skip] = group(ss.SkippedTests, skip)
+	return DisplayContent{
+		Results:       rs,
+		TotalTests:    len(ss.PassedTests) + len(ss.FailedTests) + len(ss.SkippedTests),
+		TotalDuration: ss.TotalDuration,
+		BuildVersion:  ss.GopoghVersion + "_" + ss.GopoghBuild,
+		CreatedOn:     time.Now(),
+		Detail:        ss.Detail,
+		TestTime:      ss.TestTime,
+		Owners:        ss.Owners,
+	}, nil
+}
+
+// SetOwners sets the owners of the tests of the report
+func (c *DisplayContent) SetOwners(o *owners.Owners) {
+	c.Owners = map[string]string{}
+	for _, groups := range c.Results {
+		for _, g := range groups {
+			if owner := o.Owner(g.TestName); owner != "" {
+				c.Owners[g.TestName] = owner
+			}
+		}
+	}
+}
+
+// Owner returns the owners of a test, "" if it has none
+func (c DisplayContent) Owner(test string) string {
+	return c.Owners[test]
+}
+
 // HTML returns html format
 func (c DisplayContent) HTML() ([]byte, error) {
 
 	fmap := template.FuncMap{
-		"mod": mod,
+		"mod":      mod,
+		"shortSHA": shortSHA,
 	}
 	t, err := template.New("out").Parse(templates.ReportCSS)
 	if err != nil {
@@ -98,16 +177,15 @@ func (c DisplayContent) HTML() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-// SQL handles database creation and updates
-func (c DisplayContent) SQL(flagValues db.FlagValues) error {
-	database, err := db.FromEnv(flagValues)
-	if err != nil {
-		return err
-	}
-	if err := database.Initialize(); err != nil {
-		return err
-	}
+// SQL handles database creation and updates, retrying the failed connections and writes flagValues.Retries times
+func (c DisplayContent) SQL(ctx context.Context, flagValues db.FlagValues) error {
+	dbEnvironmentRow, dbTestRows := c.DBRows()
+	_, err := db.Write(ctx, flagValues, nil, dbEnvironmentRow, dbTestRows)
+	return err
+}
 
+// DBRows returns the database rows of the report
+func (c DisplayContent) DBRows() (models.DBEnvironmentTest, []models.DBTestCase) {
 	expectedRowNumber := 0
 	for _, g := range c.Results {
 		expectedRowNumber += len(g)
@@ -116,9 +194,12 @@ func (c DisplayContent) SQL(flagValues db.FlagValues) error {
 	for resultType, testGroups := range c.Results {
 		for _, test := range testGroups {
 			r := models.DBTestCase{
+				Project:   c.Detail.RepoName,
+				Branch:    c.Detail.Branch,
 				PR:        c.Detail.PR,
-				CommitID:  c.Detail.Details,
+				CommitID:  c.Detail.CommitID(),
 				TestName:  test.TestName,
+				Owner:     c.Owner(test.TestName),
 				Result:    resultType,
 				Duration:  test.Duration,
 				EnvName:   c.Detail.Name,
@@ -129,7 +210,13 @@ func (c DisplayContent) SQL(flagValues db.FlagValues) error {
 		}
 	}
 	dbEnvironmentRow := models.DBEnvironmentTest{
-		CommitID:      c.Detail.Details,
+		Project:       c.Detail.RepoName,
+		Branch:        c.Detail.Branch,
+		PR:            c.Detail.PR,
+		CommitID:      c.Detail.CommitID(),
+		Details:       c.Detail.Details,
+		ParentCommit:  c.Detail.ParentCommit,
+		CommitOrder:   c.Detail.CommitOrder,
 		EnvName:       c.Detail.Name,
 		GopoghTime:    time.Now(),
 		TestTime:      c.TestTime,
@@ -138,9 +225,14 @@ func (c DisplayContent) SQL(flagValues db.FlagValues) error {
 		NumberOfSkip:  len(c.Results[skip]),
 		TotalDuration: c.TotalDuration,
 		GopoghVersion: c.BuildVersion,
+		GoVersion:     c.Detail.Run.GoVersion,
+		GOOS:          c.Detail.Run.GOOS,
+		GOARCH:        c.Detail.Run.GOARCH,
+		Hostname:      c.Detail.Run.Hostname,
+		Trigger:       c.Detail.Run.Trigger,
+		Labels:        c.Detail.Run.Labels,
 	}
-
-	return database.Set(dbEnvironmentRow, dbTestRows)
+	return dbEnvironmentRow, dbTestRows
 }
 
 // Generate generates a report
@@ -200,3 +292,10 @@ func Generate(report models.ReportDetail, groups []models.TestGroup) (DisplayCon
 func mod(a, b int) int {
 	return a % b
 }
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
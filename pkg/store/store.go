@@ -0,0 +1,89 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Get when nothing is stored under the key
+var ErrNotFound = errors.New("not found")
+
+// Store is the blob store interface for generated reports and raw test logs
+type Store interface {
+	Put(key string, r io.Reader) error
+
+	Get(key string) (io.ReadCloser, error)
+}
+
+// New returns the store for the given location, for now only local directories are supported
+func New(location string) (Store, error) {
+	return newLocal(location)
+}
+
+// local stores blobs as files in a directory
+type local struct {
+	dir string
+}
+
+// newLocal returns a store writing to dir, creating it if needed
+func newLocal(dir string) (*local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+	return &local{dir: dir}, nil
+}
+
+// Put writes the blob to the file of the key, replacing any previous blob
+func (l *local) Put(key string, r io.Reader) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	// write to a temporary file first so readers never see a partial blob
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", key, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to store %s: %v", key, err)
+	}
+	return nil
+}
+
+// Get opens the file of the key
+func (l *local) Get(key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// path maps a slash separated key to a file within the store directory
+func (l *local) path(key string) (string, error) {
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\:`) {
+			return "", fmt.Errorf("invalid key: %q", key)
+		}
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
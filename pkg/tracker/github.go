@@ -0,0 +1,156 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GitHubConfig configures the GitHub issue tracker
+type GitHubConfig struct {
+	// Repo is the owner/name of the repository the issues are filed in
+	Repo string `json:"repo"`
+	// Token is a token allowed to write issues, defaults to the GITHUB_TOKEN environment variable
+	Token string `json:"token"`
+	// Labels are added to the issues, the issues filed by gopogh are found by the first one
+	Labels []string `json:"labels"`
+	// APIURL is the url of the GitHub REST API, https://api.github.com if empty
+	APIURL string `json:"apiURL"`
+}
+
+const (
+	defaultGitHubAPIURL = "https://api.github.com"
+	defaultGitHubLabel  = "gopogh-flake"
+	githubTimeout       = 30 * time.Second
+)
+
+// testMarker is hidden in the issue body to find the test an issue tracks
+var testMarker = regexp.MustCompile(`<!-- gopogh test: (.*?) -->`)
+
+// GitHub files the issues through the GitHub REST API
+type GitHub struct {
+	cfg    GitHubConfig
+	client *http.Client
+}
+
+type githubIssue struct {
+	Number      int             `json:"number,omitempty"`
+	Title       string          `json:"title,omitempty"`
+	Body        string          `json:"body,omitempty"`
+	Labels      []string        `json:"labels,omitempty"`
+	State       string          `json:"state,omitempty"`
+	HTMLURL     string          `json:"html_url,omitempty"`
+	PullRequest json.RawMessage `json:"pull_request,omitempty"`
+}
+
+// NewGitHub returns a GitHub issue tracker
+func NewGitHub(cfg GitHubConfig) (*GitHub, error) {
+	if cfg.Repo == "" {
+		return nil, fmt.Errorf("missing GitHub repo")
+	}
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("GITHUB_TOKEN")
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = defaultGitHubAPIURL
+	}
+	if len(cfg.Labels) == 0 {
+		cfg.Labels = []string{defaultGitHubLabel}
+	}
+	return &GitHub{cfg: cfg, client: &http.Client{Timeout: githubTimeout}}, nil
+}
+
+// OpenIssues implements Tracker
+func (g *GitHub) OpenIssues() ([]Issue, error) {
+	var issues []Issue
+	for page := 1; ; page++ {
+		var gis []githubIssue
+		path := fmt.Sprintf("/repos/%s/issues?state=open&per_page=100&page=%d&labels=%s", g.cfg.Repo, page, url.QueryEscape(g.cfg.Labels[0]))
+		if err := g.do(http.MethodGet, path, nil, &gis); err != nil {
+			return nil, err
+		}
+		for _, gi := range gis {
+			m := testMarker.FindStringSubmatch(gi.Body)
+			if gi.PullRequest != nil || m == nil {
+				continue
+			}
+			issues = append(issues, Issue{ID: gi.Number, Test: m[1], Title: gi.Title, Body: gi.Body, URL: gi.HTMLURL})
+		}
+		if len(gis) < 100 {
+			return issues, nil
+		}
+	}
+}
+
+// Create implements Tracker
+func (g *GitHub) Create(i Issue) (Issue, error) {
+	var gi githubIssue
+	err := g.do(http.MethodPost, fmt.Sprintf("/repos/%s/issues", g.cfg.Repo), githubIssue{Title: i.Title, Body: withMarker(i), Labels: g.cfg.Labels}, &gi)
+	if err != nil {
+		return i, err
+	}
+	i.ID, i.URL = gi.Number, gi.HTMLURL
+	return i, nil
+}
+
+// Update implements Tracker
+func (g *GitHub) Update(i Issue) error {
+	return g.do(http.MethodPatch, fmt.Sprintf("/repos/%s/issues/%d", g.cfg.Repo, i.ID), githubIssue{Title: i.Title, Body: withMarker(i)}, nil)
+}
+
+// Close implements Tracker
+func (g *GitHub) Close(i Issue, comment string) error {
+	err := g.do(http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", g.cfg.Repo, i.ID), map[string]string{"body": comment}, nil)
+	if err != nil {
+		return err
+	}
+	return g.do(http.MethodPatch, fmt.Sprintf("/repos/%s/issues/%d", g.cfg.Repo, i.ID), githubIssue{State: "closed"}, nil)
+}
+
+// withMarker returns the issue body with the marker of its test
+func withMarker(i Issue) string {
+	return fmt.Sprintf("<!-- gopogh test: %s -->\n%s", i.Test, i.Body)
+}
+
+// do sends a request to the GitHub API, decoding the response into out if not nil
+func (g *GitHub) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to marshal GitHub request: %v", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(g.cfg.APIURL, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create GitHub request: %v", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if g.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.cfg.Token)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode GitHub response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+// Package tracker files and closes issues for the tests that stay flaky
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/medyagh/gopogh/pkg/db"
+	"github.com/medyagh/gopogh/pkg/models"
+)
+
+// Issue is an issue tracking a flaky test
+type Issue struct {
+	// ID identifies the issue in the tracker, for example the GitHub issue number
+	ID    int
+	Test  string
+	Title string
+	Body  string
+	URL   string
+}
+
+// Tracker is an issue tracker
+type Tracker interface {
+	// OpenIssues returns the open issues filed by gopogh
+	OpenIssues() ([]Issue, error)
+	// Create files a new issue
+	Create(i Issue) (Issue, error)
+	// Update replaces the title and body of an issue
+	Update(i Issue) error
+	// Close closes an issue with a comment
+	Close(i Issue, comment string) error
+}
+
+// FailureSource returns the test failures, implemented by db.Datab
+type FailureSource interface {
+	GetTestFailures(ctx context.Context, f db.Filter) ([]models.DBTestFailures, error)
+}
+
+const (
+	defaultDays          = 7
+	defaultThreshold     = 10
+	defaultMinRuns       = 5
+	defaultIntervalHours = 24
+	// maxReportLinks is the number of recent failing reports linked per environment
+	maxReportLinks = 5
+)
+
+// Config is the issue tracking configuration file of gopogh-server
+type Config struct {
+	// Project is the gopogh project the issues are filed for
+	Project string `json:"project"`
+	// Branch limits the runs to a branch, all post-merge runs if empty
+	Branch string `json:"branch"`
+	// Threshold is the flake percentage a test needs on an environment over the last Days days to be tracked
+	Threshold float64 `json:"threshold"`
+	Days      int     `json:"days"`
+	// MinRuns is the number of runs on an environment needed to be tracked or closed
+	MinRuns       int `json:"minRuns"`
+	IntervalHours int `json:"intervalHours"`
+	// ServerURL is the public url of gopogh-server the issues link to
+	ServerURL string        `json:"serverURL"`
+	GitHub    *GitHubConfig `json:"github"`
+}
+
+// LoadConfig reads a json issue tracking configuration file and creates its tracker
+func LoadConfig(path string) (Config, Tracker, error) {
+	var c Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c, nil, fmt.Errorf("failed to read issue tracking config: %v", err)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, nil, fmt.Errorf("failed to parse issue tracking config: %v", err)
+	}
+	if c.GitHub == nil {
+		return c, nil, fmt.Errorf("no issue tracker configured in %s", path)
+	}
+	if c.ServerURL == "" {
+		return c, nil, fmt.Errorf("no serverURL configured in %s, the issues link to the reports and charts of gopogh-server", path)
+	}
+	t, err := NewGitHub(*c.GitHub)
+	return c, t, err
+}
+
+// Job opens, updates and closes the issues of the flaky tests
+type Job struct {
+	Config  Config
+	Tracker Tracker
+	Source  FailureSource
+}
+
+// Start runs the job every IntervalHours in the background
+func (j *Job) Start() {
+	hours := j.Config.IntervalHours
+	if hours <= 0 {
+		hours = defaultIntervalHours
+	}
+	go func() {
+		for {
+			if err := j.Run(context.Background()); err != nil {
+				log.Printf("failed to update the flaky test issues: %v", err)
+			}
+			time.Sleep(time.Duration(hours) * time.Hour)
+		}
+	}()
+}
+
+// flakyTest is a test and its failures on each environment
+type flakyTest struct {
+	name     string
+	failures []models.DBTestFailures
+	runs     int
+	// flaky is whether the test is above the threshold on an environment, stable whether it did not fail on any
+	flaky, stable bool
+}
+
+// Run files an issue for each test with a flake rate above the threshold on an environment, updates the existing ones
+// and closes the issues of the tests that did not fail or did not run in the last days
+func (j *Job) Run(ctx context.Context) error {
+	c := j.Config
+	days := valueOr(c.Days, defaultDays)
+	failures, err := j.Source.GetTestFailures(ctx, db.Filter{Project: c.Project, Branch: c.Branch, From: time.Now().AddDate(0, 0, -days)})
+	if err != nil {
+		return err
+	}
+	if failures == nil {
+		return fmt.Errorf("issue tracking is not supported by the database backend")
+	}
+	tests := j.classify(failures)
+
+	issues, err := j.Tracker.OpenIssues()
+	if err != nil {
+		return err
+	}
+	open := map[string]Issue{}
+	for _, i := range issues {
+		open[i.Test] = i
+	}
+
+	for _, t := range tests {
+		issue, exists := open[t.name]
+		switch {
+		case t.flaky:
+			issue.Test, issue.Title, issue.Body = t.name, fmt.Sprintf("Frequent test failures of `%s`", t.name), j.body(t, days)
+			if exists {
+				if err := j.Tracker.Update(issue); err != nil {
+					return err
+				}
+				continue
+			}
+			if issue, err = j.Tracker.Create(issue); err != nil {
+				return err
+			}
+			log.Printf("filed %s for flaky test %s", issue.URL, t.name)
+		case t.stable && exists:
+			if err := j.Tracker.Close(issue, fmt.Sprintf("`%s` did not fail in the last %d days, closing.", t.name, days)); err != nil {
+				return err
+			}
+			log.Printf("closed %s of stable test %s", issue.URL, t.name)
+		}
+	}
+
+	// the tests without runs in the last days were removed, renamed or stopped running
+	ran := map[string]bool{}
+	for _, t := range tests {
+		ran[t.name] = true
+	}
+	for _, issue := range issues {
+		if ran[issue.Test] {
+			continue
+		}
+		if err := j.Tracker.Close(issue, fmt.Sprintf("`%s` did not run in the last %d days, closing.", issue.Test, days)); err != nil {
+			return err
+		}
+		log.Printf("closed %s of test %s without runs", issue.URL, issue.Test)
+	}
+	return nil
+}
+
+// classify groups the failures by test and tells whether each test is flaky or stable
+func (j *Job) classify(failures []models.DBTestFailures) []*flakyTest {
+	threshold := j.threshold()
+	minRuns := valueOr(j.Config.MinRuns, defaultMinRuns)
+
+	var tests []*flakyTest
+	byName := map[string]*flakyTest{}
+	for _, f := range failures {
+		t, ok := byName[f.TestName]
+		if !ok {
+			t = &flakyTest{name: f.TestName, stable: true}
+			byName[f.TestName] = t
+			tests = append(tests, t)
+		}
+		t.runs += f.Runs
+		if f.Fails > 0 {
+			t.stable = false
+		}
+		if f.Runs >= minRuns && flakeRate(f) >= threshold {
+			t.flaky = true
+			t.failures = append(t.failures, f)
+		}
+	}
+	for _, t := range tests {
+		// too few runs are not enough to call a test stable
+		if t.runs < minRuns {
+			t.stable = false
+		}
+		sort.Slice(t.failures, func(a, b int) bool { return flakeRate(t.failures[a]) > flakeRate(t.failures[b]) })
+	}
+	return tests
+}
+
+// body returns the issue body of a flaky test, with a table of its flake rate on each environment and links to the reports of the recent failures
+func (j *Job) body(t *flakyTest, days int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "`%s` failed in at least %.0f%% of its runs on these environments in the last %d days:\n\n", t.name, j.threshold(), days)
+	fmt.Fprintf(&b, "| Environment | Flake Rate | Failures | Recent failures |\n|---|---|---|---|\n")
+	for _, f := range t.failures {
+		var links []string
+		for i, commit := range f.FailedCommits {
+			if i == maxReportLinks {
+				break
+			}
+			// relative links are broken in the issues, only the commits are listed without a server
+			if j.Config.ServerURL == "" {
+				links = append(links, shortSHA(commit))
+				continue
+			}
+			links = append(links, fmt.Sprintf("[%s](%s)", shortSHA(commit), j.reportURL(f.EnvName, commit, t.name)))
+		}
+		env := f.EnvName
+		if j.Config.ServerURL != "" {
+			env = fmt.Sprintf("[%s](%s)", f.EnvName, j.chartURL(f.EnvName, t.name))
+		}
+		fmt.Fprintf(&b, "| %s | %.2f%% | %d / %d | %s |\n", env, flakeRate(f), f.Fails, f.Runs, strings.Join(links, ", "))
+	}
+	fmt.Fprintf(&b, "\nThis issue is updated by gopogh and closed once the test does not fail for %d days.\n", days)
+	return b.String()
+}
+
+// reportURL returns the link to the report of a run stored by gopogh-server
+func (j *Job) reportURL(env, commit, test string) string {
+	u := fmt.Sprintf("%s/report/%s/%s", strings.TrimSuffix(j.Config.ServerURL, "/"), url.PathEscape(env), url.PathEscape(commit))
+	if j.Config.Project != "" {
+		u += "?project=" + url.QueryEscape(j.Config.Project)
+	}
+	return u + "#fail_" + test
+}
+
+// chartURL returns the link to the flake chart of the test
+func (j *Job) chartURL(env, test string) string {
+	q := url.Values{"env": {env}, "test": {test}}
+	if j.Config.Project != "" {
+		q.Set("project", j.Config.Project)
+	}
+	return strings.TrimSuffix(j.Config.ServerURL, "/") + "/?" + q.Encode()
+}
+
+// threshold returns the flake percentage a test needs to be tracked
+func (j *Job) threshold() float64 {
+	if j.Config.Threshold <= 0 {
+		return defaultThreshold
+	}
+	return j.Config.Threshold
+}
+
+func flakeRate(f models.DBTestFailures) float64 {
+	if f.Runs == 0 {
+		return 0
+	}
+	return float64(f.Fails) * 100 / float64(f.Runs)
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func valueOr(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}